@@ -0,0 +1,177 @@
+package bilibili
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"go.uber.org/zap"
+
+	"github.com/CuteReimu/bilibili/v2"
+)
+
+const codeNotLoggedIn = -101
+
+// ErrNotLoggedIn is returned when the cookies are missing, invalid or
+// expired.
+var ErrNotLoggedIn = errors.New("not logged in, please run login first")
+
+// ErrCookiesExpired is returned when the cookies are no longer accepted, it's
+// marked as ErrNotLoggedIn.
+var ErrCookiesExpired = errors.Mark(errors.New("cookies expired, please run login again"), ErrNotLoggedIn)
+
+type NavInfo struct {
+	IsLogin bool   `json:"isLogin"`
+	Mid     int    `json:"mid"`
+	Uname   string `json:"uname"`
+}
+
+func GetNavInfo(client *bilibili.Client) (*NavInfo, error) {
+	return getResponseData[NavInfo](client, "https://api.bilibili.com/x/web-interface/nav", nil)
+}
+
+// CheckLogin returns ErrCookiesExpired if the cookies of client are no longer
+// accepted.
+func CheckLogin(client *bilibili.Client) (*NavInfo, error) {
+	info, err := GetNavInfo(client)
+	if err != nil {
+		if code, ok := apiErrorCode(err); ok && code == codeNotLoggedIn {
+			return nil, ErrCookiesExpired
+		}
+		return nil, err
+	}
+	if !info.IsLogin {
+		return nil, ErrCookiesExpired
+	}
+	return info, nil
+}
+
+// ValidateLogin checks the cookies with an authenticated API before any
+// download, so that expired cookies fail with ErrNotLoggedIn at once instead
+// of several API calls deep. The user is logged on success.
+func (d *Downloader) ValidateLogin() error {
+	info, err := CheckLogin(d.client)
+	if err != nil {
+		return errors.Wrap(err, "validate login")
+	}
+	zap.L().Info("Logged in", zap.String("user", info.Uname), zap.Int("mid", info.Mid))
+	return nil
+}
+
+// ensureLogin validates the cookies, with autoLogin it logs in with QR code
+// and saves the new cookies when they are missing or have expired.
+func (d *Downloader) ensureLogin(autoLogin bool) error {
+	var err error
+	if d.client.GetCookiesString() == "" {
+		err = ErrNotLoggedIn
+	} else {
+		err = d.ValidateLogin()
+	}
+	if err == nil || !errors.Is(err, ErrNotLoggedIn) || !autoLogin {
+		return err
+	}
+
+	zap.L().Warn("Not logged in, login again", zap.Error(err))
+	_, err = Login(d.client)
+	if err != nil {
+		return err
+	}
+	return d.SaveConfig()
+}
+
+// normalizeCookies trims a cookie string copied from the browser into the
+// "k1=v1; k2=v2" form.
+func normalizeCookies(s string) string {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "Cookie:")
+	parts := make([]string, 0)
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "; ")
+}
+
+const cookieDomain = "bilibili.com"
+
+// parseCookiesTxt extracts the bilibili cookies of a Netscape cookies.txt,
+// the format exported by browser extensions and consumed by yt-dlp. Expired
+// cookies are skipped.
+func parseCookiesTxt(r io.Reader, now time.Time) (string, error) {
+	parts := make([]string, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		// curl marks HttpOnly cookies with a prefix on the domain
+		line = strings.TrimPrefix(line, "#HttpOnly_")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return "", errors.Newf("invalid cookies.txt line: %s", line)
+		}
+		domain := strings.TrimPrefix(fields[0], ".")
+		if domain != cookieDomain && !strings.HasSuffix(domain, "."+cookieDomain) {
+			continue
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid cookies.txt expiry: %s", fields[4])
+		}
+		if expires != 0 && time.Unix(expires, 0).Before(now) {
+			continue
+		}
+		parts = append(parts, fields[5]+"="+fields[6])
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	if len(parts) == 0 {
+		return "", errors.Newf("no %s cookies found", cookieDomain)
+	}
+	return strings.Join(parts, "; "), nil
+}
+
+// LoginWithCookiesTxt validates the bilibili cookies of a Netscape
+// cookies.txt and returns them in normalized form.
+func LoginWithCookiesTxt(client *bilibili.Client, filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+
+	cookies, err := parseCookiesTxt(f, time.Now())
+	if err != nil {
+		return "", errors.Wrapf(err, "parse %s", filePath)
+	}
+	return LoginWithCookies(client, cookies)
+}
+
+// LoginWithCookies validates cookies and returns them in normalized form.
+func LoginWithCookies(client *bilibili.Client, cookies string) (string, error) {
+	cookies = normalizeCookies(cookies)
+	if cookies == "" {
+		return "", errors.New("cookie is empty")
+	}
+	client.SetCookiesString(cookies)
+
+	info, err := CheckLogin(client)
+	if err != nil {
+		if errors.Is(err, ErrCookiesExpired) {
+			return "", errors.New("cookie is invalid or expired")
+		}
+		return "", err
+	}
+
+	zap.L().Info("Login success", zap.String("user", info.Uname), zap.Int("mid", info.Mid))
+	return client.GetCookiesString(), nil
+}
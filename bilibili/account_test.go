@@ -0,0 +1,92 @@
+package bilibili
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+func TestNormalizeCookies(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"SESSDATA=a; bili_jct=b", "SESSDATA=a; bili_jct=b"},
+		{"  SESSDATA=a;bili_jct=b;  ", "SESSDATA=a; bili_jct=b"},
+		{"Cookie: SESSDATA=a;; bili_jct=b\n", "SESSDATA=a; bili_jct=b"},
+		{" ; ", ""},
+	}
+	for _, tt := range tests {
+		got := normalizeCookies(tt.input)
+		if got != tt.want {
+			t.Errorf("normalizeCookies(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseCookiesTxt(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	input := strings.Join([]string{
+		"# Netscape HTTP Cookie File",
+		"",
+		".bilibili.com\tTRUE\t/\tFALSE\t1800000000\tbuvid3\tx",
+		"#HttpOnly_.bilibili.com\tTRUE\t/\tTRUE\t1800000000\tSESSDATA\ta%2Cb",
+		"api.bilibili.com\tFALSE\t/\tFALSE\t0\tbili_jct\tc",
+		".bilibili.com\tTRUE\t/\tFALSE\t1600000000\texpired\td",
+		".example.com\tTRUE\t/\tFALSE\t0\tother\te",
+		".notbilibili.com\tTRUE\t/\tFALSE\t0\tother\tf",
+	}, "\n")
+
+	got, err := parseCookiesTxt(strings.NewReader(input), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "buvid3=x; SESSDATA=a%2Cb; bili_jct=c"
+	if got != want {
+		t.Errorf("parseCookiesTxt() = %q, want %q", got, want)
+	}
+
+	_, err = parseCookiesTxt(strings.NewReader(".example.com\tTRUE\t/\tFALSE\t0\tother\te\n"), now)
+	if err == nil {
+		t.Error("expected error without bilibili cookies")
+	}
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestValidateLogin(t *testing.T) {
+	d := newTestDownloader(t)
+
+	for _, test := range []struct {
+		body     string
+		loggedIn bool
+	}{
+		{body: `{"code":0,"data":{"isLogin":true,"mid":1,"uname":"user"}}`, loggedIn: true},
+		{body: `{"code":-101,"message":"账号未登录","data":{"isLogin":false}}`, loggedIn: false},
+		{body: `{"code":0,"data":{"isLogin":false}}`, loggedIn: false},
+	} {
+		d.client.Resty().SetTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+				Body:       io.NopCloser(strings.NewReader(test.body)),
+				Request:    req,
+			}, nil
+		}))
+		err := d.ValidateLogin()
+		if test.loggedIn && err != nil {
+			t.Errorf("ValidateLogin() with %s = %v", test.body, err)
+		}
+		if !test.loggedIn && !errors.Is(err, ErrNotLoggedIn) {
+			t.Errorf("ValidateLogin() with %s = %v, want ErrNotLoggedIn", test.body, err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package bilibili
+
+import (
+	"github.com/cockroachdb/errors"
+
+	"github.com/CuteReimu/bilibili/v2"
+)
+
+type apiResponse[T any] struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    T      `json:"data"`
+}
+
+// getResponseData calls an API endpoint not covered by the SDK client and
+// unwraps the common {code, message, data} envelope.
+func getResponseData[T any](client *bilibili.Client, url string, params map[string]string) (*T, error) {
+	var rsp apiResponse[T]
+	_, err := client.Resty().R().
+		SetQueryParams(params).
+		SetResult(&rsp).
+		Get(url)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if rsp.Code != 0 {
+		return nil, errors.WithStack(bilibili.Error{Code: rsp.Code, Message: rsp.Message})
+	}
+	return &rsp.Data, nil
+}
+
+type pgcResponse[T any] struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Result  T      `json:"result"`
+}
+
+// getPgcResult is getResponseData for PGC (bangumi) APIs, which put the
+// payload in result instead of data.
+func getPgcResult[T any](client *bilibili.Client, url string, params map[string]string) (*T, error) {
+	var rsp pgcResponse[T]
+	_, err := client.Resty().R().
+		SetQueryParams(params).
+		SetResult(&rsp).
+		Get(url)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if rsp.Code != 0 {
+		return nil, errors.WithStack(bilibili.Error{Code: rsp.Code, Message: rsp.Message})
+	}
+	return &rsp.Result, nil
+}
@@ -0,0 +1,42 @@
+package bilibili
+
+import (
+	"github.com/cockroachdb/errors"
+)
+
+var ErrNotAvailable = errors.New("not available in your region/account")
+
+// https://socialsisteryi.github.io/bilibili-API-collect/docs/video/videostream_url.html
+const (
+	codeNotFound    = -404
+	codeAreaLimited = -10403
+	codeChargeOnly  = 87007
+	codeChargeOnly2 = 87008
+	codePgcLimited  = 6010001
+)
+
+// playurlResultSuccess is the result field of successful playurl responses,
+// it's not a typo of "success".
+const playurlResultSuccess = "suee"
+
+var unavailableReasons = map[int]string{
+	codeNotFound:    "video not found or deleted",
+	codeAreaLimited: "region locked",
+	codeChargeOnly:  "for charging members only",
+	codeChargeOnly2: "for charging members only",
+	codePgcLimited:  "region or VIP locked",
+}
+
+// wrapNotAvailable wraps API errors of videos the account can't watch with
+// ErrNotAvailable and the reason, other errors are returned as is.
+func wrapNotAvailable(err error) error {
+	code, ok := apiErrorCode(err)
+	if !ok {
+		return err
+	}
+	reason, ok := unavailableReasons[code]
+	if !ok {
+		return err
+	}
+	return errors.Wrapf(ErrNotAvailable, "%s: %v", reason, err)
+}
@@ -0,0 +1,23 @@
+package bilibili
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/CuteReimu/bilibili/v2"
+)
+
+func TestWrapNotAvailable(t *testing.T) {
+	err := wrapNotAvailable(errors.WithStack(bilibili.Error{Code: codeAreaLimited, Message: "抱歉您所在地区不可观看！"}))
+	if !errors.Is(err, ErrNotAvailable) {
+		t.Errorf("region locked error = %v, want ErrNotAvailable", err)
+	}
+
+	for _, code := range []int{codeRateLimited, codeAccessDenied} {
+		err = wrapNotAvailable(bilibili.Error{Code: code})
+		if errors.Is(err, ErrNotAvailable) {
+			t.Errorf("error of code %d = %v, should not be ErrNotAvailable", code, err)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package bilibili
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/urfave/cli/v3"
+	"go.uber.org/zap"
+
+	"github.com/CuteReimu/bilibili/v2"
+)
+
+type BangumiEpisode struct {
+	Id        int    `json:"id"`
+	Aid       int    `json:"aid"`
+	Bvid      string `json:"bvid"`
+	Cid       int    `json:"cid"`
+	Title     string `json:"title"`
+	LongTitle string `json:"long_title"`
+	ShowTitle string `json:"show_title"`
+	Duration  int    `json:"duration"`
+}
+
+func (e *BangumiEpisode) DisplayTitle() string {
+	if e.ShowTitle != "" {
+		return e.ShowTitle
+	}
+	return strings.TrimSpace(e.Title + " " + e.LongTitle)
+}
+
+type BangumiSeason struct {
+	SeasonId    int              `json:"season_id"`
+	SeasonTitle string           `json:"season_title"`
+	Title       string           `json:"title"`
+	Episodes    []BangumiEpisode `json:"episodes"`
+}
+
+// GetBangumiSeason gets the season by either epId or seasonId.
+func (d *Downloader) GetBangumiSeason(ctx context.Context, epId int, seasonId int) (*BangumiSeason, error) {
+	params := map[string]string{}
+	if epId != 0 {
+		params["ep_id"] = strconv.Itoa(epId)
+	} else {
+		params["season_id"] = strconv.Itoa(seasonId)
+	}
+	return retryAPI(ctx, d.maxRetries, func() (*BangumiSeason, error) {
+		client, err := d.GetClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return getPgcResult[BangumiSeason](client, "https://api.bilibili.com/pgc/view/web/season", params)
+	})
+}
+
+func (d *Downloader) GetBangumiVideoStream(ctx context.Context, option DownloadOption) (*bilibili.VideoStream, error) {
+	stream, err := retryAPI(ctx, d.maxRetries, func() (*bilibili.VideoStream, error) {
+		client, err := d.GetClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return getPgcResult[bilibili.VideoStream](client, "https://api.bilibili.com/pgc/player/web/playurl", map[string]string{
+			"ep_id": strconv.Itoa(option.EpId),
+			"cid":   strconv.Itoa(option.Cid),
+			"fnval": strconv.Itoa(16 | 128),
+			"fourk": "1",
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrapf(wrapNotAvailable(err), "ep%d %s", option.EpId, option.Title)
+	}
+	return stream, nil
+}
+
+var downloadBangumiCmd = &cli.Command{
+	Name:  "bangumi",
+	Usage: "Download bangumi episodes by ep or season ID",
+	Flags: slices.Concat(
+		[]cli.Flag{
+			&cli.IntFlag{
+				Name:  "ep",
+				Usage: "Episode ID, the number after ep in the URL",
+			},
+			&cli.IntFlag{
+				Name:  "ss",
+				Usage: "Season ID, the number after ss in the URL, downloads all episodes",
+			},
+			&cli.IntFlag{
+				Name:  "concurrency",
+				Usage: "Number of videos to download in parallel",
+				Value: 1,
+			},
+		},
+		DownloadFlags(),
+		batchFlags(),
+		notifyFlags(),
+	),
+	Action: func(ctx context.Context, command *cli.Command) error {
+		epId := command.Int("ep")
+		seasonId := command.Int("ss")
+		if epId == 0 && seasonId == 0 {
+			return errors.New("ep/ss is required")
+		}
+
+		d, err := downloaderFromCliCommand(command)
+		if err != nil {
+			return err
+		}
+
+		season, err := d.GetBangumiSeason(ctx, epId, seasonId)
+		if err != nil {
+			return err
+		}
+		zap.L().Info("Bangumi", zap.String("title", season.Title), zap.Int("episodes", len(season.Episodes)))
+
+		episodes := make([]BangumiEpisode, 0, len(season.Episodes))
+		for _, e := range season.Episodes {
+			if epId != 0 && e.Id != epId {
+				continue
+			}
+			ok, err := d.IsDownloaded(e.Bvid, e.Cid)
+			if err != nil {
+				return err
+			}
+			if ok {
+				d.alreadyDownloaded.Add(1)
+				continue
+			}
+			episodes = append(episodes, e)
+		}
+		if epId != 0 && len(episodes) == 0 {
+			zap.L().Info("Episode already downloaded or not found", zap.Int("ep", epId))
+		}
+
+		options := make([]DownloadOption, 0, len(episodes))
+		for i, e := range episodes {
+			options = append(options, DownloadOption{
+				Bvid:             e.Bvid,
+				Cid:              e.Cid,
+				EpId:             e.Id,
+				OwnerName:        season.Title,
+				Title:            e.DisplayTitle(),
+				Duration:         time.Duration(e.Duration) * time.Millisecond,
+				DownloadProgress: fmt.Sprintf("(%d/%d)", i+1, len(episodes)),
+			})
+		}
+
+		if command.Bool("dry-run") {
+			return printCandidates(options)
+		}
+		return d.finishBatch(command, d.DownloadBatch(ctx, options, command.Int("concurrency")))
+	},
+}
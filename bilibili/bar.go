@@ -2,25 +2,53 @@ package bilibili
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/k0kubun/go-ansi"
 	"github.com/schollz/progressbar/v3"
+	"golang.org/x/term"
 )
 
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+// barWriter serializes rendering so bars of concurrent downloads don't interleave.
+var barWriter io.Writer = &syncWriter{w: ansi.NewAnsiStdout()}
+
+// progressEnabled controls whether progress bars are rendered, they are off
+// by default when stdout is not a terminal to keep logs of cron jobs clean.
+var progressEnabled = term.IsTerminal(int(os.Stdout.Fd()))
+
+func SetProgressEnabled(enabled bool) {
+	progressEnabled = enabled
+}
+
 func NewProgressBar(maxBytes int64, description string) *progressbar.ProgressBar {
 	return progressbar.NewOptions64(
 		maxBytes,
 		progressbar.OptionSetDescription(description),
-		progressbar.OptionSetWriter(ansi.NewAnsiStdout()),
+		progressbar.OptionSetWriter(barWriter),
+		progressbar.OptionSetVisibility(progressEnabled),
 		progressbar.OptionEnableColorCodes(true),
 		progressbar.OptionShowBytes(true),
 		progressbar.OptionShowTotalBytes(true),
 		progressbar.OptionThrottle(100*time.Millisecond),
 		progressbar.OptionShowCount(),
 		progressbar.OptionOnCompletion(func() {
-			_, _ = fmt.Fprint(os.Stderr, "\n")
+			if progressEnabled {
+				_, _ = fmt.Fprint(barWriter, "\n")
+			}
 		}),
 		progressbar.OptionSpinnerType(14),
 		progressbar.OptionFullWidth(),
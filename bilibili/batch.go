@@ -0,0 +1,385 @@
+package bilibili
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/urfave/cli/v3"
+	"go.uber.org/zap"
+)
+
+// History entries of a batch are written in batches of historyBufferSize,
+// and at least every historyFlushInterval, so that a crash loses only a few.
+const (
+	historyBufferSize    = 20
+	historyFlushInterval = 30 * time.Second
+)
+
+type BatchFailure struct {
+	Bvid  string
+	Title string
+	Err   error
+}
+
+type BatchSummary struct {
+	Total     int
+	Succeeded int
+	Failed    []BatchFailure
+	// Restricted are the videos the account can't watch, or can't yet
+	// because they are premieres, they are not failures
+	Restricted []BatchFailure
+	// NotStarted are the options never started because the batch was stopped
+	NotStarted int
+
+	// Candidates counts the options and the videos filtered out before the
+	// batch because they were already downloaded
+	Candidates int
+	// Downloaded are the succeeded options not skipped as already downloaded
+	Downloaded int
+	// AlreadyDownloaded are the candidates skipped because history or the
+	// output file has them, or they were found restricted recently
+	AlreadyDownloaded int
+	// TotalBytes is the size of the merged files of the batch
+	TotalBytes int64
+}
+
+type batchReportFailure struct {
+	Bvid  string `json:"bvid"`
+	Title string `json:"title"`
+	Error string `json:"error"`
+}
+
+type batchReport struct {
+	Candidates int                  `json:"candidates"`
+	Downloaded int                  `json:"downloaded"`
+	Skipped    int                  `json:"skipped"`
+	NotStarted int                  `json:"not_started"`
+	Failed     []batchReportFailure `json:"failed"`
+	Restricted []batchReportFailure `json:"restricted"`
+	TotalBytes int64                `json:"total_bytes"`
+}
+
+func reportFailures(failures []BatchFailure) []batchReportFailure {
+	report := make([]batchReportFailure, 0, len(failures))
+	for _, f := range failures {
+		report = append(report, batchReportFailure{
+			Bvid:  f.Bvid,
+			Title: f.Title,
+			Error: f.Err.Error(),
+		})
+	}
+	return report
+}
+
+func (s *BatchSummary) report() batchReport {
+	report := batchReport{
+		Candidates: s.Candidates,
+		Downloaded: s.Downloaded,
+		Skipped:    s.AlreadyDownloaded,
+		NotStarted: s.NotStarted,
+		Failed:     reportFailures(s.Failed),
+		Restricted: reportFailures(s.Restricted),
+		TotalBytes: s.TotalBytes,
+	}
+	return report
+}
+
+// WriteReport writes the summary as JSON to filePath for monitoring
+// automated runs.
+func (s *BatchSummary) WriteReport(filePath string) error {
+	buf, err := json.MarshalIndent(s.report(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, buf, 0644)
+}
+
+// Log logs the counts of the summary, failures are logged by DownloadBatch as
+// they happen.
+func (s *BatchSummary) Log() {
+	zap.L().Info("Batch completed", zap.Int("total", s.Total),
+		zap.Int("succeeded", s.Succeeded), zap.Int("failed", len(s.Failed)), zap.Int("restricted", len(s.Restricted)),
+		zap.Int("notStarted", s.NotStarted),
+		zap.Int("alreadyDownloaded", s.AlreadyDownloaded), zap.Int64("totalBytes", s.TotalBytes))
+}
+
+// DownloadBatch downloads options with a bounded pool of workers. Failures are
+// collected into the summary instead of aborting the batch.
+func (d *Downloader) DownloadBatch(ctx context.Context, options []DownloadOption, concurrency int) *BatchSummary {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	if d.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.deadline)
+		defer cancel()
+	}
+
+	stopBuffering := d.bufferHistory()
+	defer stopBuffering()
+
+	alreadyDownloaded := d.alreadyDownloaded.Load()
+	totalSize := d.totalSize.Load()
+	summary := &BatchSummary{
+		Total:      len(options),
+		Candidates: len(options) + int(alreadyDownloaded),
+	}
+	var diskFull atomic.Bool
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	jobs := make(chan DownloadOption)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for option := range jobs {
+				err := d.downloadWithTimeout(ctx, option)
+				restricted := errors.Is(err, ErrNotAvailable) || errors.Is(err, ErrNotPublished)
+				if err != nil && !restricted {
+					zap.L().Error("Download failed", zap.String("bvid", option.Bvid),
+						zap.String("title", option.Title), zap.Error(err))
+					if errors.Is(err, ErrInsufficientSpace) {
+						diskFull.Store(true)
+					}
+				}
+
+				mu.Lock()
+				switch {
+				case restricted:
+					summary.Restricted = append(summary.Restricted, BatchFailure{
+						Bvid:  option.Bvid,
+						Title: option.Title,
+						Err:   err,
+					})
+				case err != nil:
+					summary.Failed = append(summary.Failed, BatchFailure{
+						Bvid:  option.Bvid,
+						Title: option.Title,
+						Err:   err,
+					})
+				default:
+					summary.Succeeded++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	dispatched := 0
+dispatch:
+	for i, option := range options {
+		if d.totalSizeExceeded() {
+			zap.L().Warn("Total size limit reached, stop downloading",
+				zap.Int64("totalSize", d.totalSize.Load()), zap.Int64("maxTotalSize", d.maxTotalSize),
+				zap.Int("skipped", len(options)-i))
+			break
+		}
+		if diskFull.Load() {
+			zap.L().Error("Disk space is running low, stop downloading", zap.Int("skipped", len(options)-i))
+			break
+		}
+		select {
+		case jobs <- option:
+			dispatched++
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				zap.L().Warn("Batch deadline exceeded", zap.Duration("deadline", d.deadline),
+					zap.Int("skipped", len(options)-i))
+			} else {
+				zap.L().Info("Batch cancelled", zap.Error(ctx.Err()))
+			}
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	summary.NotStarted = len(options) - dispatched
+	summary.AlreadyDownloaded = int(d.alreadyDownloaded.Load())
+	summary.Downloaded = summary.Succeeded - int(d.alreadyDownloaded.Load()-alreadyDownloaded)
+	summary.TotalBytes = d.totalSize.Load() - totalSize
+
+	return summary
+}
+
+// bufferHistory buffers history saves and flushes them periodically until
+// the returned function is called, which flushes the rest.
+func (d *Downloader) bufferHistory() func() {
+	d.history.BufferSaves(historyBufferSize)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(historyFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := d.history.Flush()
+				if err != nil {
+					zap.L().Error("Save history failed", zap.Error(err))
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+		d.history.BufferSaves(0)
+		err := d.history.Flush()
+		if err != nil {
+			zap.L().Error("Save history failed", zap.Error(err))
+		}
+	}
+}
+
+// finishBatch logs the summary, sends the notifications, writes the report
+// if --report is set and saves the config.
+func (d *Downloader) finishBatch(command *cli.Command, summary *BatchSummary) error {
+	summary.Log()
+	notifyBatch(command, summary)
+	if report := command.String("report"); report != "" {
+		err := summary.WriteReport(report)
+		if err != nil {
+			return errors.Wrap(err, "write report")
+		}
+	}
+	return d.SaveConfig()
+}
+
+// downloadWithTimeout downloads option within the item timeout, so that a
+// stuck video doesn't hold up the batch.
+func (d *Downloader) downloadWithTimeout(ctx context.Context, option DownloadOption) error {
+	if d.itemTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.itemTimeout)
+		defer cancel()
+	}
+	return d.downloadWithRetries(ctx, option)
+}
+
+// printCandidates lists what a batch would download without downloading.
+func printCandidates(options []DownloadOption) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "BVID\tAuthor\tTitle\tDuration")
+	for _, option := range options {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", option.Bvid, option.OwnerName, option.Title, option.Duration)
+	}
+	return w.Flush()
+}
+
+// selectCandidates lists options with numbers and reads from r which of them
+// to download.
+func selectCandidates(r io.Reader, w io.Writer, options []DownloadOption) ([]DownloadOption, error) {
+	if len(options) == 0 {
+		return options, nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "#\tAuthor\tTitle\tDuration")
+	for i, option := range options {
+		_, _ = fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", i+1, option.OwnerName, option.Title, option.Duration)
+	}
+	err := tw.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(r)
+	for {
+		_, _ = fmt.Fprint(w, "Select videos to download (e.g. 1,3,5-7, all or none): ")
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return nil, err
+			}
+			return nil, errors.New("no selection")
+		}
+
+		indexes, err := parseSelection(scanner.Text(), len(options))
+		if err != nil {
+			_, _ = fmt.Fprintln(w, err)
+			continue
+		}
+
+		selected := make([]DownloadOption, 0, len(indexes))
+		for _, i := range indexes {
+			option := options[i]
+			option.DownloadProgress = fmt.Sprintf("(%d/%d)", len(selected)+1, len(indexes))
+			selected = append(selected, option)
+		}
+		return selected, nil
+	}
+}
+
+// parseSelection parses a selection like "1,3,5-7" of 1-based numbers up to
+// n into sorted 0-based indexes.
+func parseSelection(s string, n int) ([]int, error) {
+	s = strings.TrimSpace(strings.ToLower(s))
+	switch s {
+	case "all", "a":
+		indexes := make([]int, n)
+		for i := range indexes {
+			indexes[i] = i
+		}
+		return indexes, nil
+	case "none", "":
+		return []int{}, nil
+	}
+
+	parseNumber := func(v string) (int, error) {
+		i, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || i < 1 || i > n {
+			return 0, errors.Newf("invalid number: %s, should be 1-%d", v, n)
+		}
+		return i, nil
+	}
+
+	selected := make(map[int]bool)
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		from, to, isRange := strings.Cut(part, "-")
+		start, err := parseNumber(from)
+		if err != nil {
+			return nil, err
+		}
+		end := start
+		if isRange {
+			end, err = parseNumber(to)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if start > end {
+			return nil, errors.Newf("invalid range: %s", part)
+		}
+		for i := start; i <= end; i++ {
+			selected[i-1] = true
+		}
+	}
+
+	indexes := make([]int, 0, len(selected))
+	for i := range selected {
+		indexes = append(indexes, i)
+	}
+	slices.Sort(indexes)
+	return indexes, nil
+}
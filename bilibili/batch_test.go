@@ -0,0 +1,89 @@
+package bilibili
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+)
+
+func TestParseSelection(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		want  []int
+	}{
+		{input: "1,3", want: []int{0, 2}},
+		{input: " 5-7 , 1, 6", want: []int{0, 4, 5, 6}},
+		{input: "all", want: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{input: "none", want: []int{}},
+		{input: "", want: []int{}},
+	} {
+		got, err := parseSelection(test.input, 10)
+		if err != nil {
+			t.Errorf("parseSelection(%q): %v", test.input, err)
+			continue
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("parseSelection(%q) = %v, want %v", test.input, got, test.want)
+		}
+	}
+
+	for _, input := range []string{"0", "11", "3-1", "x", "1-"} {
+		_, err := parseSelection(input, 10)
+		if err == nil {
+			t.Errorf("parseSelection(%q): expected error", input)
+		}
+	}
+}
+
+func TestSelectCandidates(t *testing.T) {
+	options := []DownloadOption{{Bvid: "a"}, {Bvid: "b"}, {Bvid: "c"}}
+	selected, err := selectCandidates(strings.NewReader("9\n1,3\n"), io.Discard, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(selected) != 2 || selected[0].Bvid != "a" || selected[1].Bvid != "c" {
+		t.Errorf("selected = %+v", selected)
+	}
+	if selected[1].DownloadProgress != "(2/2)" {
+		t.Errorf("DownloadProgress = %s", selected[1].DownloadProgress)
+	}
+}
+
+func TestBatchSummaryWriteReport(t *testing.T) {
+	summary := &BatchSummary{
+		Total:             3,
+		Succeeded:         2,
+		Failed:            []BatchFailure{{Bvid: "c", Title: "C", Err: errors.New("boom")}},
+		Candidates:        5,
+		Downloaded:        1,
+		AlreadyDownloaded: 3,
+		TotalBytes:        1024,
+	}
+	filePath := filepath.Join(t.TempDir(), "report.json")
+	err := summary.WriteReport(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report batchReport
+	err = json.Unmarshal(buf, &report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Candidates != 5 || report.Downloaded != 1 || report.Skipped != 3 || report.TotalBytes != 1024 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if len(report.Failed) != 1 || report.Failed[0].Bvid != "c" || report.Failed[0].Error != "boom" {
+		t.Errorf("unexpected failures: %+v", report.Failed)
+	}
+}
@@ -3,26 +3,33 @@ package bilibili
 import (
 	"context"
 	"fmt"
-	"io"
 	"math"
-	"net/http"
-	"os"
-	"path/filepath"
 	"runtime"
-	"strconv"
-	"strings"
+	"slices"
 	"time"
 
 	"github.com/cockroachdb/errors"
-	"github.com/flytam/filenamify"
-	"github.com/go-resty/resty/v2"
 	"github.com/urfave/cli/v3"
 	"go.uber.org/zap"
 
 	"github.com/CuteReimu/bilibili/v2"
+	"github.com/fanyang89/media-collector/internal/downloader"
 )
 
-const readStreamSliceTimeout = 30 * time.Second
+const (
+	defaultReadTimeout     = 30 * time.Second
+	defaultDownloadTimeout = 20 * time.Minute
+
+	defaultDownloadAttempts      = downloader.DefaultAttempts
+	defaultDownloadRetryInterval = downloader.DefaultRetryInterval
+)
+
+// The CDN rejects requests without these headers with 403, backup URLs on
+// some hosts in particular.
+const (
+	downloadReferer   = "https://www.bilibili.com"
+	downloadUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36"
+)
 
 type VideoAudioPair struct {
 	VideoPath  string
@@ -46,21 +53,49 @@ var loginCmd = &cli.Command{
 			Aliases: []string{"c"},
 			Value:   "config.yml",
 		},
+		&cli.StringFlag{
+			Name:  "profile",
+			Usage: "Account profile in config",
+			Value: defaultProfile,
+		},
+		&cli.StringFlag{
+			Name:  "cookie",
+			Usage: "Login with a cookie string like \"SESSDATA=...; bili_jct=...\" instead of QR code",
+		},
+		&cli.StringFlag{
+			Name:  "from-cookies-txt",
+			Usage: "Login with the bilibili cookies of a Netscape cookies.txt exported from the browser",
+		},
+		&cli.StringFlag{
+			Name:  "cookie-file",
+			Usage: "Save cookies to this file instead of the config, sets cookies_file in config",
+		},
 	},
 	Action: func(ctx context.Context, command *cli.Command) error {
-		configPath := command.String("config")
+		configPath := configPathFromCliCommand(command)
 		config, err := LoadConfig(configPath)
 		if err != nil {
 			return err
 		}
 
+		if command.IsSet("cookie-file") {
+			config.CookiesFile = command.String("cookie-file")
+		}
+
 		client := bilibili.New()
-		cookies, err := Login(client)
+		var cookies string
+		if command.IsSet("cookie") {
+			cookies, err = LoginWithCookies(client, command.String("cookie"))
+		} else if command.IsSet("from-cookies-txt") {
+			cookies, err = LoginWithCookiesTxt(client, command.String("from-cookies-txt"))
+		} else {
+			cookies, err = Login(client)
+		}
 		if err != nil {
 			return err
 		}
 
-		config.Cookies = cookies
+		config.SetCookies(command.String("profile"), cookies)
 		return SaveConfig(configPath, config)
 	},
 }
@@ -72,6 +107,11 @@ var downloadCmd = &cli.Command{
 		downloadToViewCmd,
 		downloadSingleCmd,
 		downloadSearchCmd,
+		downloadSpaceCmd,
+		downloadFavCmd,
+		downloadSeasonCmd,
+		downloadBangumiCmd,
+		downloadWatchlistCmd,
 	},
 }
 
@@ -102,50 +142,78 @@ func NewGetVideoStreamParam(bvid string, cid int) bilibili.GetVideoStreamParam {
 var downloadToViewCmd = &cli.Command{
 	Name:  "to-view",
 	Usage: "Download to-view (playback later) videos",
-	Flags: []cli.Flag{
-		&cli.StringFlag{
-			Name:    "config",
-			Aliases: []string{"c"},
-			Value:   "config.yml",
-		},
-		&cli.StringFlag{
-			Name:    "output",
-			Aliases: []string{"o"},
-			Value:   "./output",
-		},
-		&cli.StringFlag{
-			Name:  "ffmpeg",
-			Value: "ffmpeg" + defaultExecutableFileExtension(),
+	Flags: slices.Concat(
+		[]cli.Flag{
+			&cli.DurationFlag{
+				Name:  "since",
+				Usage: "Only download videos added to to-view within this duration, e.g. 168h, 0 means all",
+			},
 		},
-	},
+		DownloadFlags(),
+		batchFlags(),
+		notifyFlags(),
+	),
 	Action: func(ctx context.Context, command *cli.Command) error {
 		d, err := downloaderFromCliCommand(command)
 		if err != nil {
 			return err
 		}
 
-		toViewList, err := d.GetClient().GetToViewList()
+		toViewList, err := retryAPI(ctx, d.maxRetries, func() (*bilibili.ToViewList, error) {
+			client, err := d.GetClient(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return client.GetToViewList()
+		})
 		if err != nil {
 			return err
 		}
 
+		since := sinceCutoff(command)
+		options := make([]DownloadOption, 0, len(toViewList.List))
 		for _, v := range toViewList.List {
-			err = d.Download(DownloadOption{
+			if time.Unix(int64(v.AddAt), 0).Before(since) {
+				continue
+			}
+			ok, err := d.IsDownloaded(v.Bvid, v.Cid)
+			if err != nil {
+				return err
+			}
+			if ok {
+				d.alreadyDownloaded.Add(1)
+				continue
+			}
+
+			options = append(options, DownloadOption{
 				Bvid:      v.Bvid,
 				Cid:       v.Cid,
 				OwnerName: v.Owner.Name,
 				Title:     v.Title,
-			}, false, true)
-			if err != nil {
-				zap.L().Error("Download failed", zap.String("bvid", v.Bvid), zap.Error(err))
-				continue
-			}
+				Duration:  time.Duration(v.Duration) * time.Second,
+			})
+		}
+		for i := range options {
+			options[i].DownloadProgress = fmt.Sprintf("(%d/%d)", i+1, len(options))
 		}
 
-		return nil
+		if command.Bool("dry-run") {
+			return printCandidates(options)
+		}
+		return d.finishBatch(command, d.DownloadBatch(ctx, options, 1))
 	},
 }
 
+// sinceCutoff returns the time before which videos are ignored by --since,
+// the zero time if it's not set.
+func sinceCutoff(command *cli.Command) time.Time {
+	since := command.Duration("since")
+	if since <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(-since)
+}
+
 var RootCmd = &cli.Command{
 	Name:    "bilibili",
 	Usage:   "Commands for Bilibili",
@@ -153,148 +221,52 @@ var RootCmd = &cli.Command{
 	Commands: []*cli.Command{
 		loginCmd,
 		downloadCmd,
+		historyCmd,
 	},
 }
 
-func getContentLength(header http.Header) int64 {
-	s := header.Get("Content-Length")
-	v, err := strconv.ParseInt(s, 10, 64)
-	if err != nil {
-		return -1
-	}
-	return v
-}
-
-func copyRestyClient(c *resty.Client) *resty.Client {
-	cc := *c
-	return &cc
-}
-
-var ErrFileTooLarge = errors.New("file too large")
-
-func (d *Downloader) downloadSingleFile(filePath string, url string) error {
-	fileName := filepath.Base(filePath)
-	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		return err
-	}
-	defer func() { _ = f.Close() }()
-
-	client := d.GetClient()
-	c := copyRestyClient(client.Resty())
-	c.SetTimeout(20 * time.Minute)
-
-	rsp, err := c.R().SetDoNotParseResponse(true).Get(url)
-	if err != nil {
-		return err
-	}
-	body := rsp.RawBody()
-	defer func() { _ = body.Close() }()
-
-	fmt.Printf("Downloading %s\n", fileName)
-	contentLength := getContentLength(rsp.Header())
-	if d.maxFileSize > 0 && contentLength >= d.maxFileSize {
-		return errors.Wrapf(ErrFileTooLarge, "file: %s", fileName)
-	}
-
-	bar := NewProgressBar(contentLength, "")
-	defer func() { _ = bar.Finish() }()
+var (
+	ErrFileTooLarge       = downloader.ErrFileTooLarge
+	ErrIncompleteDownload = downloader.ErrIncompleteDownload
+	ErrDownloadForbidden  = downloader.ErrForbidden
+)
 
-	buf := make([]byte, 1*1024*1024)
-	writer := io.MultiWriter(f, bar)
+const partFileSuffix = downloader.PartFileSuffix
 
-	for {
-		ctx, cancel := context.WithTimeout(context.Background(), readStreamSliceTimeout)
-		var n int
-		n, err = readWithContext(ctx, body, buf)
-		cancel()
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				return nil
-			}
-			return err
-		}
+func (d *Downloader) DownloadFile(ctx context.Context, filePath string, urls []string) error {
+	return d.downloadFile(ctx, filePath, urls, true)
+}
 
-		_, err = writer.Write(buf[:n])
+// downloadFile downloads the first of urls that works to filePath, the
+// progress bar is hidden unless showProgress is set.
+func (d *Downloader) downloadFile(ctx context.Context, filePath string, urls []string, showProgress bool) error {
+	client := d.downloadClient
+	if client == nil {
+		c, err := d.GetClient(ctx)
 		if err != nil {
 			return err
 		}
+		client = c.Resty()
 	}
-}
-
-func readWithContext(ctx context.Context, r io.Reader, buf []byte) (n int, err error) {
-	done := make(chan struct{})
-	go func() {
-		n, err = r.Read(buf)
-		close(done)
-	}()
-
-	select {
-	case <-done:
-		return n, err
-	case <-ctx.Done():
-		return 0, ctx.Err()
-	}
-}
-
-func (d *Downloader) DownloadFile(filePath string, urls []string) error {
-	if len(urls) == 0 {
-		return errors.New("urls is empty")
-	}
-
-	if len(urls) > 1 {
-		for _, url := range urls {
-			err := d.downloadSingleFile(filePath, url)
-			if err != nil {
-				if errors.Is(err, ErrFileTooLarge) {
-					return err
-				}
-				zap.L().Error("Download file failed, try next URL", zap.Error(err))
-				continue
-			}
-			return nil
-		}
-	}
-
-	if len(urls) == 1 {
-		tryCnt := 0
-		const maxTryCnt = 5
-		const tryInterval = time.Second
-		for tryCnt < maxTryCnt {
-			tryCnt++
-			err := d.downloadSingleFile(filePath, urls[0])
-			if err != nil {
-				if errors.Is(err, ErrFileTooLarge) {
-					return err
-				}
-				zap.L().Error("Download file failed, try again later", zap.Error(err))
-				time.Sleep(tryInterval)
-			} else {
+	return downloader.Download(ctx, filePath, urls, downloader.Options{
+		Client: client,
+		Header: map[string]string{
+			"Referer":    downloadReferer,
+			"User-Agent": downloadUserAgent,
+		},
+		ReadTimeout:     d.readTimeout,
+		DownloadTimeout: d.downloadTimeout,
+		MaxFileSize:     d.maxFileSize,
+		Attempts:        d.downloadAttempts,
+		RetryInterval:   d.downloadRetryInterval,
+		Progress: func(fileName string, contentLength int64) downloader.ProgressBar {
+			_, _ = fmt.Fprintf(barWriter, "Downloading %s\n", fileName)
+			if !showProgress {
 				return nil
 			}
-		}
-	}
-
-	fileName := filepath.Base(filePath)
-	return errors.Newf("download %s failed", fileName)
-}
-
-func newFileName(author string, title string, suffix string, format string) string {
-	if strings.Contains(format, "mp4") {
-		format = "mp4"
-	} else if strings.Contains(format, "flv") {
-		format = "flv"
-	}
-	if suffix != "" {
-		suffix = "_" + suffix
-	}
-
-	fileName := fmt.Sprintf("%s - %s%s.%s", author, title, suffix, format)
-	fileName, err := filenamify.FilenamifyV2(fileName)
-	if err != nil {
-		panic(err)
-	}
-	return fileName
+			return NewProgressBar(contentLength, "")
+		},
+	})
 }
 
 func Login(client *bilibili.Client) (string, error) {
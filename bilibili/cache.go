@@ -0,0 +1,54 @@
+package bilibili
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultVideoInfoCacheTTL keeps video info for the length of a typical
+// batch, titles and parts rarely change within it.
+const defaultVideoInfoCacheTTL = 10 * time.Minute
+
+type cacheEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// ttlCache is an in-memory cache whose entries expire after ttl, a ttl of 0
+// or less disables it.
+type ttlCache[V any] struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]cacheEntry[V]
+	now     func() time.Time
+}
+
+func newTTLCache[V any](ttl time.Duration) *ttlCache[V] {
+	return &ttlCache[V]{
+		ttl:     ttl,
+		entries: make(map[string]cacheEntry[V]),
+		now:     time.Now,
+	}
+}
+
+func (c *ttlCache[V]) Get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || !c.now().Before(entry.expires) {
+		delete(c.entries, key)
+		var zero V
+		return zero, false
+	}
+	return entry.value, true
+}
+
+func (c *ttlCache[V]) Set(key string, value V) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = cacheEntry[V]{value: value, expires: c.now().Add(c.ttl)}
+}
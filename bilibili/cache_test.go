@@ -0,0 +1,31 @@
+package bilibili
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTLCache(t *testing.T) {
+	now := time.Now()
+	c := newTTLCache[int](time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.Set("a", 1)
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Errorf("Get(a) = %d, %v, want 1", v, ok)
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Error("Get(b) should miss")
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := c.Get("a"); ok {
+		t.Error("Get(a) should miss after ttl")
+	}
+
+	disabled := newTTLCache[int](0)
+	disabled.Set("a", 1)
+	if _, ok := disabled.Get("a"); ok {
+		t.Error("cache with ttl 0 should be disabled")
+	}
+}
@@ -0,0 +1,188 @@
+package bilibili
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/CuteReimu/bilibili/v2"
+)
+
+const (
+	codecAny  = "any"
+	codecAVC  = "avc"
+	codecHEVC = "hevc"
+	codecAV1  = "av1"
+)
+
+// defaultCompatCRF is the CRF of x264 when transcoding with --compat, its
+// own default which looks fine for most videos.
+const defaultCompatCRF = 23
+
+// https://socialsisteryi.github.io/bilibili-API-collect/docs/video/videostream_url.html#%E8%A7%86%E9%A2%91%E7%BC%96%E7%A0%81%E4%BB%A3%E7%A0%81
+var codecIds = map[string]int{
+	codecAVC:  7,
+	codecHEVC: 12,
+	codecAV1:  13,
+}
+
+var codecsPrefixes = map[string][]string{
+	codecAVC:  {"avc1"},
+	codecHEVC: {"hev1", "hvc1"},
+	codecAV1:  {"av01"},
+}
+
+func validateCodec(codec string) error {
+	if _, ok := codecIds[codec]; !ok && codec != codecAny {
+		return errors.Newf("invalid codec: %s, should be avc, hevc, av1 or any", codec)
+	}
+	return nil
+}
+
+func matchCodec(stream *bilibili.AudioOrVideo, codec string) bool {
+	if codec == codecAny {
+		return true
+	}
+	if stream.Codecid != 0 {
+		return stream.Codecid == codecIds[codec]
+	}
+	for _, prefix := range codecsPrefixes[codec] {
+		if strings.HasPrefix(stream.Codecs, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortByBandwidth sorts streams from the best to the worst.
+func sortByBandwidth(streams []bilibili.AudioOrVideo) {
+	slices.SortFunc(streams, func(a, b bilibili.AudioOrVideo) int { return b.Bandwidth - a.Bandwidth })
+}
+
+// selectVideoStream returns the first stream of videos in the preferred
+// codec, or the first stream and false if no stream is in that codec.
+func selectVideoStream(videos []bilibili.AudioOrVideo, codec string) (bilibili.AudioOrVideo, bool) {
+	for _, v := range videos {
+		if matchCodec(&v, codec) {
+			return v, true
+		}
+	}
+	return videos[0], false
+}
+
+const (
+	audioQualityNormal = "normal"
+	audioQualityBest   = "best"
+	audioQualityFlac   = "flac"
+	audioQualityDolby  = "dolby"
+)
+
+func validateAudioQuality(quality string) error {
+	switch quality {
+	case audioQualityNormal, audioQualityBest, audioQualityFlac, audioQualityDolby:
+		return nil
+	}
+	return errors.Newf("invalid audio quality: %s, should be normal, best, flac or dolby", quality)
+}
+
+// Formats of --extract-audio-format, native keeps the downloaded audio track
+// in m4a without transcoding.
+const (
+	audioFormatNative = ""
+	audioFormatM4a    = "m4a"
+	audioFormatMp3    = "mp3"
+	audioFormatFlac   = "flac"
+)
+
+const defaultAudioBitrate = "192k"
+
+func validateAudioFormat(format string) error {
+	switch format {
+	case audioFormatNative, audioFormatM4a, audioFormatMp3, audioFormatFlac:
+		return nil
+	}
+	return errors.Newf("invalid audio format: %s, should be mp3, m4a or flac", format)
+}
+
+// selectAudioStream returns the Hi-Res (flac) or Dolby track of dash if
+// quality asks for it and it exists, best prefers flac to dolby. Otherwise
+// it returns the best of the normal tracks, with false if that's a fallback.
+// The normal tracks must be sorted by bandwidth.
+func selectAudioStream(dash *bilibili.Dash, quality string) (bilibili.AudioOrVideo, bool) {
+	hasFlac := dash.Flac != nil && dash.Flac.Audio != nil
+	hasDolby := len(dash.Dolby.Audio) > 0
+	switch quality {
+	case audioQualityFlac, audioQualityBest:
+		if hasFlac {
+			return *dash.Flac.Audio, true
+		}
+		if quality == audioQualityBest && hasDolby {
+			return dash.Dolby.Audio[0], true
+		}
+	case audioQualityDolby:
+		if hasDolby {
+			return dash.Dolby.Audio[0], true
+		}
+	default:
+		return dash.Audio[0], true
+	}
+	return dash.Audio[0], quality == audioQualityBest
+}
+
+// https://socialsisteryi.github.io/bilibili-API-collect/docs/video/videostream_url.html#qn%E8%A7%86%E9%A2%91%E6%B8%85%E6%99%B0%E5%BA%A6%E6%A0%87%E8%AF%86
+var audioQualityLabels = map[int]string{
+	30216: "64K",
+	30232: "132K",
+	30280: "192K",
+	30250: "Dolby",
+	30251: "Hi-Res",
+}
+
+// videoQualityLabel returns the description of quality id from the accepted
+// qualities of the stream, or the id itself if it's not described.
+func videoQualityLabel(result *bilibili.VideoStream, id int) string {
+	i := slices.Index(result.AcceptQuality, id)
+	if i >= 0 && i < len(result.AcceptDescription) {
+		return result.AcceptDescription[i]
+	}
+	return strconv.Itoa(id)
+}
+
+func audioQualityLabel(id int) string {
+	if label, ok := audioQualityLabels[id]; ok {
+		return label
+	}
+	return strconv.Itoa(id)
+}
+
+// printFormats lists the streams of result like yt-dlp -F.
+func printFormats(w io.Writer, result *bilibili.VideoStream) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "Type\tID\tQuality\tCodecs\tResolution\tBandwidth\tMime")
+	videos := slices.Clone(result.Dash.Video)
+	sortByBandwidth(videos)
+	for _, v := range videos {
+		_, _ = fmt.Fprintf(tw, "video\t%d\t%s\t%s\t%dx%d\t%d\t%s\n", v.Id, videoQualityLabel(result, v.Id),
+			v.Codecs, v.Width, v.Height, v.Bandwidth, v.MimeType)
+	}
+	audios := slices.Clone(result.Dash.Audio)
+	sortByBandwidth(audios)
+	if result.Dash.Flac != nil && result.Dash.Flac.Audio != nil {
+		audios = append(audios, *result.Dash.Flac.Audio)
+	}
+	audios = append(audios, result.Dash.Dolby.Audio...)
+	for _, a := range audios {
+		_, _ = fmt.Fprintf(tw, "audio\t%d\t%s\t%s\t\t%d\t%s\n", a.Id, audioQualityLabel(a.Id),
+			a.Codecs, a.Bandwidth, a.MimeType)
+	}
+	for i := range result.Durl {
+		_, _ = fmt.Fprintf(tw, "muxed\t%d\t%s\t%s\t\t\t\n", i+1, videoQualityLabel(result, result.Quality),
+			result.Format)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,94 @@
+package bilibili
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/CuteReimu/bilibili/v2"
+)
+
+func TestSelectVideoStream(t *testing.T) {
+	videos := []bilibili.AudioOrVideo{
+		{Id: 1, Codecid: 13, Codecs: "av01.0.08M.08.0.110.01.01.01.0"},
+		{Id: 2, Codecid: 12, Codecs: "hev1.1.6.L120.90"},
+		{Id: 3, Codecs: "avc1.640032"},
+	}
+	for _, test := range []struct {
+		codec string
+		id    int
+		ok    bool
+	}{
+		{codec: codecAny, id: 1, ok: true},
+		{codec: codecAV1, id: 1, ok: true},
+		{codec: codecHEVC, id: 2, ok: true},
+		{codec: codecAVC, id: 3, ok: true},
+	} {
+		v, ok := selectVideoStream(videos, test.codec)
+		if v.Id != test.id || ok != test.ok {
+			t.Errorf("selectVideoStream(%s) = %d, %v, want %d, %v", test.codec, v.Id, ok, test.id, test.ok)
+		}
+	}
+
+	v, ok := selectVideoStream(videos[:2], codecAVC)
+	if v.Id != 1 || ok {
+		t.Errorf("selectVideoStream without avc = %d, %v, want fallback to 1", v.Id, ok)
+	}
+}
+
+func TestSelectAudioStream(t *testing.T) {
+	normal := []bilibili.AudioOrVideo{{Id: 30280}, {Id: 30216}}
+	flac := &bilibili.DashFlac{Audio: &bilibili.AudioOrVideo{Id: 30251}}
+	dolby := bilibili.DashDolby{Audio: []bilibili.AudioOrVideo{{Id: 30250}}}
+	for _, test := range []struct {
+		name    string
+		dash    bilibili.Dash
+		quality string
+		id      int
+		ok      bool
+	}{
+		{name: "normal", dash: bilibili.Dash{Audio: normal, Flac: flac, Dolby: dolby}, quality: audioQualityNormal, id: 30280, ok: true},
+		{name: "flac", dash: bilibili.Dash{Audio: normal, Flac: flac, Dolby: dolby}, quality: audioQualityFlac, id: 30251, ok: true},
+		{name: "dolby", dash: bilibili.Dash{Audio: normal, Flac: flac, Dolby: dolby}, quality: audioQualityDolby, id: 30250, ok: true},
+		{name: "best prefers flac", dash: bilibili.Dash{Audio: normal, Flac: flac, Dolby: dolby}, quality: audioQualityBest, id: 30251, ok: true},
+		{name: "best without flac", dash: bilibili.Dash{Audio: normal, Dolby: dolby}, quality: audioQualityBest, id: 30250, ok: true},
+		{name: "best without both", dash: bilibili.Dash{Audio: normal}, quality: audioQualityBest, id: 30280, ok: true},
+		{name: "flac fallback", dash: bilibili.Dash{Audio: normal, Flac: &bilibili.DashFlac{}}, quality: audioQualityFlac, id: 30280},
+		{name: "dolby fallback", dash: bilibili.Dash{Audio: normal}, quality: audioQualityDolby, id: 30280},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			a, ok := selectAudioStream(&test.dash, test.quality)
+			if a.Id != test.id || ok != test.ok {
+				t.Errorf("selectAudioStream(%s) = %d, %v, want %d, %v", test.quality, a.Id, ok, test.id, test.ok)
+			}
+		})
+	}
+}
+
+func TestPrintFormats(t *testing.T) {
+	result := &bilibili.VideoStream{
+		AcceptQuality:     []int{80, 64},
+		AcceptDescription: []string{"1080P", "720P"},
+		Dash: bilibili.Dash{
+			Video: []bilibili.AudioOrVideo{
+				{Id: 64, Codecs: "avc1.64001F", Width: 1280, Height: 720, Bandwidth: 1000},
+				{Id: 80, Codecs: "hev1.1.6.L120.90", Width: 1920, Height: 1080, Bandwidth: 2000},
+			},
+			Audio: []bilibili.AudioOrVideo{{Id: 30280, Codecs: "mp4a.40.2", Bandwidth: 192000}},
+		},
+	}
+
+	var b strings.Builder
+	err := printFormats(&b, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 lines, got %q", b.String())
+	}
+	for i, want := range []string{"1080P", "720P", "192K"} {
+		if !strings.Contains(lines[i+1], want) {
+			t.Errorf("line %d = %q, want %q in it", i+1, lines[i+1], want)
+		}
+	}
+}
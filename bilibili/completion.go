@@ -0,0 +1,67 @@
+package bilibili
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+// flagValues are what shell completion offers for flags taking one of a
+// fixed set of values.
+var flagValues = map[string][]string{
+	"container":            {containerMp4, containerMkv},
+	"codec":                {codecAny, codecAVC, codecHEVC, codecAV1},
+	"audio-quality":        {audioQualityNormal, audioQualityBest, audioQualityFlac, audioQualityDolby},
+	"extract-audio-format": {audioFormatMp3, audioFormatM4a, audioFormatFlac},
+	"group-by":             {groupByNone, groupByAuthor, groupByKeyword, groupByDate},
+	"format":               {"csv", "json", "xlsx"},
+}
+
+// completeFlagValues completes the value of a flag in flagValues and flag
+// names, or subcommands otherwise. The args are read from os.Args because a
+// flag waiting for its value fails parsing and leaves command.Args() empty.
+func completeFlagValues(ctx context.Context, command *cli.Command) {
+	args := os.Args
+	if n := len(args); n > 0 && args[n-1] == "--generate-shell-completion" {
+		args = args[:n-1]
+	}
+	if len(args) > 0 {
+		last := args[len(args)-1]
+		name, isFlag := strings.CutPrefix(last, "-")
+		name = strings.TrimPrefix(name, "-")
+		if isFlag {
+			w := command.Root().Writer
+			if values, ok := flagValues[name]; ok {
+				for _, v := range values {
+					_, _ = fmt.Fprintln(w, v)
+				}
+				return
+			}
+			for _, flag := range command.Flags {
+				for _, n := range flag.Names() {
+					if len(n) > 1 && strings.HasPrefix(n, name) {
+						_, _ = fmt.Fprintln(w, "--"+n)
+					}
+				}
+			}
+			return
+		}
+	}
+	cli.DefaultCompleteWithFlags(ctx, command)
+}
+
+func setShellComplete(command *cli.Command) {
+	if command.ShellComplete == nil {
+		command.ShellComplete = completeFlagValues
+	}
+	for _, c := range command.Commands {
+		setShellComplete(c)
+	}
+}
+
+func init() {
+	setShellComplete(RootCmd)
+}
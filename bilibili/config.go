@@ -2,27 +2,159 @@ package bilibili
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/cockroachdb/errors"
+	"github.com/urfave/cli/v3"
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Cookies   string `yaml:"cookies"`
-	Output    string `yaml:"output"`
-	FFmpeg    string `yaml:"ffmpeg"`
-	HistoryDB string `yaml:"history_db"`
+	Cookies               string        `yaml:"cookies"`
+	CookiesFile           string        `yaml:"cookies_file,omitempty"`
+	Output                string        `yaml:"output"`
+	FFmpeg                string        `yaml:"ffmpeg"`
+	FFprobe               string        `yaml:"ffprobe"`
+	Verify                bool          `yaml:"verify"`
+	HistoryDriver         string        `yaml:"history_driver"`
+	HistoryDB             string        `yaml:"history_db"`
+	MaxFileSize           int64         `yaml:"max_file_size"`
+	MaxTotalSize          int64         `yaml:"max_total_size"`
+	MinFreeSpace          int64         `yaml:"min_free_space"`
+	OutputTemplate        string        `yaml:"output_template"`
+	MaxFileNameLength     int           `yaml:"max_file_name_length"`
+	MaxRetries            int           `yaml:"max_retries"`
+	DownloadAttempts      int           `yaml:"download_attempts"`
+	DownloadRetryInterval time.Duration `yaml:"download_retry_interval"`
+	Retries               int           `yaml:"retries"`
+	ReadTimeout           time.Duration `yaml:"read_timeout"`
+	DownloadTimeout       time.Duration `yaml:"download_timeout"`
+	Container             string        `yaml:"container"`
+	Codec                 string        `yaml:"codec"`
+	AudioQuality          string        `yaml:"audio_quality"`
+	Compat                bool          `yaml:"compat"`
+	VideoInfoCacheTTL     time.Duration `yaml:"video_info_cache_ttl"`
+	CompatCRF             int           `yaml:"compat_crf"`
+	Proxies               []string      `yaml:"proxies,omitempty"`
+
+	Profiles  map[string]*Profile `yaml:"profiles,omitempty"`
+	Watchlist []WatchlistEntry    `yaml:"watchlist,omitempty"`
+}
+
+const defaultProfile = "default"
+
+// Profile overrides the account and output directory of Config.
+type Profile struct {
+	Cookies string `yaml:"cookies"`
+	Output  string `yaml:"output,omitempty"`
+}
+
+// WithProfile returns the config with the named profile applied. The default
+// profile falls back to the top-level cookies when it's not defined.
+func (c *Config) WithProfile(name string) (*Config, error) {
+	if name == "" {
+		name = defaultProfile
+	}
+	p, ok := c.Profiles[name]
+	if !ok {
+		if name == defaultProfile {
+			return c, nil
+		}
+		return nil, errors.Newf("profile not found: %s", name)
+	}
+
+	config := *c
+	config.Cookies = p.Cookies
+	if p.Output != "" {
+		config.Output = p.Output
+	}
+	return &config, nil
+}
+
+func (c *Config) SetCookies(profile string, cookies string) {
+	if profile == "" {
+		profile = defaultProfile
+	}
+	if p, ok := c.Profiles[profile]; ok {
+		p.Cookies = cookies
+		return
+	}
+	if profile == defaultProfile {
+		c.Cookies = cookies
+		return
+	}
+	if c.Profiles == nil {
+		c.Profiles = make(map[string]*Profile)
+	}
+	c.Profiles[profile] = &Profile{Cookies: cookies}
 }
 
 func defaultConfig() *Config {
 	return &Config{
-		Cookies:   "",
-		Output:    "./output",
-		FFmpeg:    "ffmpeg" + defaultExecutableFileExtension(),
-		HistoryDB: "./media-collector.db",
+		Cookies:               "",
+		Output:                "./output",
+		FFmpeg:                "ffmpeg" + defaultExecutableFileExtension(),
+		HistoryDriver:         historyDriverSqlite,
+		HistoryDB:             "./media-collector.db",
+		OutputTemplate:        defaultOutputTemplate,
+		MaxFileNameLength:     defaultMaxFileNameLength,
+		MaxRetries:            defaultMaxRetries,
+		DownloadAttempts:      defaultDownloadAttempts,
+		DownloadRetryInterval: defaultDownloadRetryInterval,
+		ReadTimeout:           defaultReadTimeout,
+		DownloadTimeout:       defaultDownloadTimeout,
+		Container:             containerMp4,
+		Codec:                 codecAny,
+		AudioQuality:          audioQualityNormal,
+		CompatCRF:             defaultCompatCRF,
+		VideoInfoCacheTTL:     defaultVideoInfoCacheTTL,
 	}
 }
 
+// CookiesEnv overrides the cookies of the config, so that CI can inject them
+// from a secret store without writing files.
+const CookiesEnv = "BILIBILI_COOKIES"
+
+// ConfigPathEnv overrides the config path when --config is not given.
+const ConfigPathEnv = "MEDIA_COLLECTOR_CONFIG"
+
+// ResolveConfigPath returns the config file to use. Unless the path is given
+// explicitly, it is looked up in $MEDIA_COLLECTOR_CONFIG, then the given path,
+// then $XDG_CONFIG_HOME/media-collector/config.yml.
+func ResolveConfigPath(path string, explicit bool) string {
+	if explicit {
+		return path
+	}
+	if env := os.Getenv(ConfigPathEnv); env != "" {
+		return env
+	}
+	if fileExists(path) {
+		return path
+	}
+
+	dir, err := userConfigDir()
+	if err == nil {
+		p := filepath.Join(dir, "media-collector", "config.yml")
+		if fileExists(p) {
+			return p
+		}
+	}
+	return path
+}
+
+func userConfigDir() (string, error) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return dir, nil
+	}
+	return os.UserConfigDir()
+}
+
+func configPathFromCliCommand(command *cli.Command) string {
+	return ResolveConfigPath(command.String("config"), command.IsSet("config"))
+}
+
 func LoadConfig(path string) (*Config, error) {
 	buf, err := os.ReadFile(path)
 	if err != nil {
@@ -32,15 +164,101 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, err
 	}
 
-	var config Config
-	err = yaml.Unmarshal(buf, &config)
+	config := defaultConfig()
+	err = yaml.Unmarshal(buf, config)
 	if err != nil {
 		return nil, err
 	}
-	return &config, nil
+	if config.DownloadAttempts <= 0 {
+		return nil, errors.Newf("invalid download_attempts: %d, should be at least 1", config.DownloadAttempts)
+	}
+
+	if config.CookiesFile != "" {
+		err = readCookiesFile(cookiesFilePath(path, config.CookiesFile), &config.Cookies)
+		if err != nil {
+			return nil, err
+		}
+		for name, p := range config.Profiles {
+			err = readCookiesFile(cookiesFilePath(path, profileCookiesFile(config.CookiesFile, name)), &p.Cookies)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return config, nil
 }
 
+// readCookiesFile reads the cookies of path into cookies, a missing file
+// leaves them as they are.
+func readCookiesFile(path string, cookies *string) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	*cookies = strings.TrimSpace(string(buf))
+	return nil
+}
+
+// writeCookiesFile writes cookies to path, creating its directory.
+func writeCookiesFile(path string, cookies string) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(cookies), 0600)
+}
+
+// profileCookiesFile names the cookies file of profile next to cookiesFile,
+// cookies.txt of profile work is cookies.work.txt.
+func profileCookiesFile(cookiesFile string, profile string) string {
+	ext := filepath.Ext(cookiesFile)
+	return strings.TrimSuffix(cookiesFile, ext) + "." + profile + ext
+}
+
+// cookiesFilePath resolves cookiesFile relative to the directory of the
+// config file.
+func cookiesFilePath(configPath string, cookiesFile string) string {
+	if filepath.IsAbs(cookiesFile) {
+		return cookiesFile
+	}
+	return filepath.Join(filepath.Dir(configPath), cookiesFile)
+}
+
+// SaveConfig writes config to path, cookies are written to the cookies file
+// instead when it's set, those of profiles to the files of
+// profileCookiesFile.
 func SaveConfig(path string, config *Config) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	if config.CookiesFile != "" {
+		err = writeCookiesFile(cookiesFilePath(path, config.CookiesFile), config.Cookies)
+		if err != nil {
+			return err
+		}
+
+		c := *config
+		c.Cookies = ""
+		if config.Profiles != nil {
+			c.Profiles = make(map[string]*Profile, len(config.Profiles))
+		}
+		for name, p := range config.Profiles {
+			err = writeCookiesFile(cookiesFilePath(path, profileCookiesFile(config.CookiesFile, name)), p.Cookies)
+			if err != nil {
+				return err
+			}
+			profile := *p
+			profile.Cookies = ""
+			c.Profiles[name] = &profile
+		}
+		config = &c
+	}
+
 	buf, err := yaml.Marshal(config)
 	if err != nil {
 		return err
@@ -0,0 +1,117 @@
+package bilibili
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/urfave/cli/v3"
+	"golang.org/x/term"
+)
+
+// detectFFmpeg returns the ffmpeg on PATH, or the default name if there's
+// none.
+func detectFFmpeg() string {
+	path, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return defaultConfig().FFmpeg
+	}
+	return path
+}
+
+// prompt asks question on w and reads the answer from r, an empty answer
+// means def.
+func prompt(r *bufio.Reader, w io.Writer, question string, def string) (string, error) {
+	_, _ = fmt.Fprintf(w, "%s [%s]: ", question, def)
+	line, err := r.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return def, nil
+	}
+	return line, nil
+}
+
+var configInitCmd = &cli.Command{
+	Name:  "init",
+	Usage: "Write a starter config file",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "config",
+			Aliases: []string{"c"},
+			Value:   "config.yml",
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "Output directory, asked interactively if not given",
+		},
+		&cli.StringFlag{
+			Name:  "ffmpeg",
+			Usage: "Path of ffmpeg, detected on PATH and asked interactively if not given",
+		},
+		&cli.BoolFlag{
+			Name:    "yes",
+			Aliases: []string{"y"},
+			Usage:   "Don't ask, use flags and defaults",
+		},
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "Overwrite the config file if it exists",
+		},
+	},
+	Action: func(ctx context.Context, command *cli.Command) error {
+		path := command.String("config")
+		if fileExists(path) && !command.Bool("force") {
+			return errors.Newf("%s exists, use --force to overwrite it", path)
+		}
+
+		config := defaultConfig()
+		config.FFmpeg = detectFFmpeg()
+		if command.IsSet("output") {
+			config.Output = command.String("output")
+		}
+		if command.IsSet("ffmpeg") {
+			config.FFmpeg = command.String("ffmpeg")
+		}
+
+		if !command.Bool("yes") && term.IsTerminal(int(os.Stdin.Fd())) {
+			r := bufio.NewReader(os.Stdin)
+			var err error
+			if !command.IsSet("output") {
+				config.Output, err = prompt(r, os.Stdout, "Output directory", config.Output)
+				if err != nil {
+					return err
+				}
+			}
+			if !command.IsSet("ffmpeg") {
+				config.FFmpeg, err = prompt(r, os.Stdout, "Path of ffmpeg", config.FFmpeg)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		err := SaveConfig(path, config)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Config written to %s, login with `media-collector bilibili login`\n", path)
+		return nil
+	},
+}
+
+var ConfigCmd = &cli.Command{
+	Name:  "config",
+	Usage: "Manage the config file",
+	Commands: []*cli.Command{
+		configInitCmd,
+	},
+}
@@ -0,0 +1,155 @@
+package bilibili
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigDefaults(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yml")
+	err := os.WriteFile(configPath, []byte("cookies: a=b\nread_timeout: 0s\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Cookies != "a=b" {
+		t.Errorf("Cookies = %q", config.Cookies)
+	}
+	if config.ReadTimeout != 0 {
+		t.Errorf("ReadTimeout = %v, want 0", config.ReadTimeout)
+	}
+	if config.DownloadTimeout != defaultDownloadTimeout {
+		t.Errorf("DownloadTimeout = %v, want %v", config.DownloadTimeout, defaultDownloadTimeout)
+	}
+
+	err = os.WriteFile(configPath, []byte("download_attempts: 0\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadConfig(configPath)
+	if err == nil {
+		t.Error("LoadConfig() with download_attempts 0 succeeded")
+	}
+}
+
+func TestResolveConfigPath(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv(ConfigPathEnv, "")
+
+	missing := filepath.Join(dir, "missing.yml")
+	if got := ResolveConfigPath(missing, false); got != missing {
+		t.Errorf("ResolveConfigPath() = %q, want %q", got, missing)
+	}
+
+	xdgPath := filepath.Join(dir, "media-collector", "config.yml")
+	err := SaveConfig(xdgPath, defaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ResolveConfigPath(missing, false); got != xdgPath {
+		t.Errorf("ResolveConfigPath() = %q, want %q", got, xdgPath)
+	}
+	if got := ResolveConfigPath(missing, true); got != missing {
+		t.Errorf("ResolveConfigPath() = %q, want %q", got, missing)
+	}
+
+	envPath := filepath.Join(dir, "env.yml")
+	t.Setenv(ConfigPathEnv, envPath)
+	if got := ResolveConfigPath(missing, false); got != envPath {
+		t.Errorf("ResolveConfigPath() = %q, want %q", got, envPath)
+	}
+}
+
+func TestConfigProfiles(t *testing.T) {
+	config := defaultConfig()
+	config.Cookies = "default"
+	config.SetCookies("premium", "premium")
+	config.Profiles["premium"].Output = "premium-output"
+
+	c, err := config.WithProfile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Cookies != "default" || c.Output != config.Output {
+		t.Errorf("default profile = %+v", c)
+	}
+
+	c, err = config.WithProfile("premium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Cookies != "premium" || c.Output != "premium-output" {
+		t.Errorf("premium profile = %+v", c)
+	}
+	if config.Cookies != "default" {
+		t.Error("WithProfile modified the config")
+	}
+
+	_, err = config.WithProfile("missing")
+	if err == nil {
+		t.Error("expected error for missing profile")
+	}
+}
+
+func TestConfigCookiesFile(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yml")
+	config := defaultConfig()
+	config.CookiesFile = "cookies.txt"
+	config.Cookies = "SESSDATA=a"
+	config.SetCookies("work", "SESSDATA=b")
+
+	err := SaveConfig(configPath, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(buf), "SESSDATA") {
+		t.Error("cookies are written to config")
+	}
+	if config.Cookies != "SESSDATA=a" || config.Profiles["work"].Cookies != "SESSDATA=b" {
+		t.Error("SaveConfig modified the config")
+	}
+
+	loaded, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Cookies != "SESSDATA=a" {
+		t.Errorf("Cookies = %q", loaded.Cookies)
+	}
+	if p := loaded.Profiles["work"]; p == nil || p.Cookies != "SESSDATA=b" {
+		t.Errorf("Profiles[work] = %+v", p)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "cookies.work.txt")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPrompt(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("\n  /data/videos  \nlast"))
+	var w strings.Builder
+	for _, want := range []string{"default", "/data/videos", "last", "default"} {
+		got, err := prompt(r, &w, "Question", "default")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("prompt() = %q, want %q", got, want)
+		}
+	}
+	if !strings.HasPrefix(w.String(), "Question [default]: ") {
+		t.Errorf("unexpected prompt: %q", w.String())
+	}
+}
@@ -0,0 +1,42 @@
+package bilibili
+
+import (
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"go.uber.org/zap"
+
+	"github.com/CuteReimu/bilibili/v2"
+)
+
+var ErrInsufficientSpace = errors.New("insufficient disk space")
+
+// estimateDownloadSize estimates the size of streams from their bandwidth,
+// 0 if the duration is unknown.
+func estimateDownloadSize(duration time.Duration, streams ...bilibili.AudioOrVideo) uint64 {
+	var bandwidth uint64
+	for _, s := range streams {
+		bandwidth += uint64(s.Bandwidth)
+	}
+	return bandwidth / 8 * uint64(duration.Seconds())
+}
+
+// checkFreeSpace fails if the output filesystem would have less than
+// minFreeSpace left, the streams and the merged file need about twice the
+// estimated size until the streams are removed.
+func (d *Downloader) checkFreeSpace(estimatedSize uint64) error {
+	if d.minFreeSpace <= 0 {
+		return nil
+	}
+	free, err := freeSpace(d.outputPath)
+	if err != nil {
+		zap.L().Warn("Get free disk space failed", zap.String("path", d.outputPath), zap.Error(err))
+		return nil
+	}
+
+	required := uint64(d.minFreeSpace) + 2*estimatedSize
+	if free < required {
+		return errors.Wrapf(ErrInsufficientSpace, "%d bytes free on %s, %d bytes required", free, d.outputPath, required)
+	}
+	return nil
+}
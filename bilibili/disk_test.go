@@ -0,0 +1,31 @@
+package bilibili
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/CuteReimu/bilibili/v2"
+)
+
+func TestEstimateDownloadSize(t *testing.T) {
+	size := estimateDownloadSize(time.Minute, bilibili.AudioOrVideo{Bandwidth: 800_000}, bilibili.AudioOrVideo{Bandwidth: 80_000})
+	if size != 110_000*60 {
+		t.Errorf("estimateDownloadSize = %d", size)
+	}
+}
+
+func TestCheckFreeSpace(t *testing.T) {
+	d := &Downloader{outputPath: t.TempDir(), minFreeSpace: 1}
+	err := d.checkFreeSpace(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.minFreeSpace = math.MaxInt64
+	err = d.checkFreeSpace(0)
+	if !errors.Is(err, ErrInsufficientSpace) {
+		t.Errorf("checkFreeSpace = %v, want ErrInsufficientSpace", err)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package bilibili
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// freeSpace returns the bytes available to unprivileged users on the
+// filesystem of path.
+func freeSpace(path string) (uint64, error) {
+	var stat unix.Statfs_t
+	err := unix.Statfs(path, &stat)
+	if err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
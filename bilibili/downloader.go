@@ -7,126 +7,412 @@ import (
 	"math/rand/v2"
 	"os"
 	"path/filepath"
-	"slices"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cockroachdb/errors"
+	"github.com/go-resty/resty/v2"
 	"github.com/urfave/cli/v3"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 	"golang.org/x/time/rate"
 
 	"github.com/CuteReimu/bilibili/v2"
 )
 
 type Downloader struct {
-	ffmpeg      FFmpeg
-	outputPath  string
-	client      *bilibili.Client
-	configPath  string
-	config      *Config
-	history     *History
-	rateLimiter *rate.Limiter
-	maxFileSize int64
+	ffmpeg     FFmpeg
+	outputPath string
+	fileNamer  *fileNamer
+	client     *bilibili.Client
+	configPath string
+	config     *Config
+	// cookiesFromEnv is set when the cookies come from $BILIBILI_COOKIES,
+	// they are never written to the config then
+	cookiesFromEnv bool
+	profile        string
+	history        *History
+	rateLimiter    *rate.Limiter
+	// videoInfoCache caches GetVideoInfo by bvid
+	videoInfoCache *ttlCache[*bilibili.VideoInfo]
+	maxFileSize    int64
+	maxRetries     int
+	container      string
+	codec          string
+	// compat transcodes AV1 videos to H.264 with compatCRF
+	compat    bool
+	compatCRF int
+	// audioQuality picks the Hi-Res or Dolby audio track, see selectAudioStream
+	audioQuality string
+	// groupBy puts merged files into subdirectories by author, keyword or date
+	groupBy string
+
+	// maxTotalSize limits the total size of merged files of a run
+	maxTotalSize int64
+	totalSize    atomic.Int64
+	minFreeSpace int64
+
+	// alreadyDownloaded counts videos skipped because history or the output
+	// file has them
+	alreadyDownloaded atomic.Int64
+
+	// reservedNames maps output files named in this run to their bvid, so
+	// that concurrent videos with the same name don't write the same file
+	reservedNames   map[string]string
+	reservedNamesMu sync.Mutex
+
+	// overwrite ignores history and existing files, skipExisting skips
+	// videos whose output file exists even if history doesn't have them.
+	// Files on disk unknown to history are never taken as a video's, see
+	// outputFile
+	overwrite    bool
+	skipExisting bool
+
+	// writeInfoJSON and writeDescription write the metadata of videos next
+	// to them
+	writeInfoJSON    bool
+	writeDescription bool
+
+	// audioOnly downloads only the audio track, extracted in
+	// extractAudioFormat at audioBitrate, see FFmpeg.ExtractAudio
+	audioOnly          bool
+	extractAudioFormat string
+	audioBitrate       string
+
+	// allBranches downloads every branch of interactive videos
+	allBranches bool
+
+	// keepTemp keeps the streams of a failed merge, so that the next run
+	// merges them again instead of downloading them
+	keepTemp bool
+
+	// deadline limits the duration of a batch, itemTimeout of a video in it
+	deadline    time.Duration
+	itemTimeout time.Duration
+
+	readTimeout     time.Duration
+	downloadTimeout time.Duration
+
+	// downloadAttempts limits the attempts of downloading a file with a
+	// single URL, retried with exponential backoff from downloadRetryInterval
+	downloadAttempts      int
+	downloadRetryInterval time.Duration
+	// retries limits the retries of a whole video after a transient failure,
+	// see retryDownload
+	retries int
+
+	// downloadClient downloads streams instead of the client of the API when
+	// set, bypassing the rate limiter
+	downloadClient *resty.Client
 }
 
 func downloaderFromCliCommand(command *cli.Command) (*Downloader, error) {
-	return newDownloader(command.String("config"))
+	d, err := newDownloader(configPathFromCliCommand(command), command.String("profile"), configOverrides{
+		FFmpeg: command.String("ffmpeg"),
+		Output: command.String("output"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if command.Bool("verify") {
+		d.ffmpeg.Verify = true
+	}
+	if d.ffmpeg.Verify {
+		d.ffmpeg.FFprobe, err = resolveFFprobe(d.ffmpeg.FFprobe)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if command.IsSet("read-timeout") {
+		d.readTimeout = command.Duration("read-timeout")
+	}
+	if command.IsSet("download-timeout") {
+		d.downloadTimeout = command.Duration("download-timeout")
+	}
+	if command.IsSet("container") {
+		d.container = command.String("container")
+	}
+	err = validateContainer(d.container)
+	if err != nil {
+		return nil, err
+	}
+	if command.IsSet("codec") {
+		d.codec = command.String("codec")
+	}
+	err = validateCodec(d.codec)
+	if err != nil {
+		return nil, err
+	}
+	if command.IsSet("compat") {
+		d.compat = command.Bool("compat")
+	}
+	if command.IsSet("compat-crf") {
+		d.compatCRF = command.Int("compat-crf")
+	}
+	if d.compatCRF < 0 || d.compatCRF > 51 {
+		return nil, errors.Newf("invalid compat crf: %d, should be between 0 and 51", d.compatCRF)
+	}
+	if command.IsSet("audio-quality") {
+		d.audioQuality = command.String("audio-quality")
+	}
+	err = validateAudioQuality(d.audioQuality)
+	if err != nil {
+		return nil, err
+	}
+	d.groupBy = command.String("group-by")
+	err = validateGroupBy(d.groupBy)
+	if err != nil {
+		return nil, err
+	}
+	if command.IsSet("max-total-size") {
+		d.maxTotalSize = command.Int64("max-total-size")
+	}
+	if command.IsSet("min-free-space") {
+		d.minFreeSpace = command.Int64("min-free-space")
+	}
+	d.overwrite = command.Bool("overwrite")
+	if command.IsSet("skip-existing") {
+		d.skipExisting = command.Bool("skip-existing")
+	}
+	d.audioOnly = command.Bool("audio-only")
+	d.extractAudioFormat = command.String("extract-audio-format")
+	err = validateAudioFormat(d.extractAudioFormat)
+	if err != nil {
+		return nil, err
+	}
+	if d.extractAudioFormat != audioFormatNative && !d.audioOnly {
+		return nil, errors.New("--extract-audio-format requires --audio-only")
+	}
+	d.audioBitrate = command.String("audio-bitrate")
+	d.allBranches = command.Bool("all-branches")
+	d.writeInfoJSON = command.Bool("write-info-json")
+	d.writeDescription = command.Bool("write-description")
+	if command.IsSet("keep-temp") {
+		d.keepTemp = command.Bool("keep-temp")
+	}
+	if command.IsSet("proxy-list") {
+		err = d.setProxies(command.StringSlice("proxy-list"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if command.IsSet("retries") {
+		d.retries = command.Int("retries")
+	}
+	d.deadline = command.Duration("deadline")
+	d.itemTimeout = command.Duration("item-timeout")
+	err = d.ensureLogin(command.Bool("auto-login"))
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
 }
 
-func NewDownloaderFromConfig(config *Config) *Downloader {
-	b := bilibili.New()
-	b.SetCookiesString(config.Cookies)
-	return &Downloader{
-		config:      config,
-		ffmpeg:      FFmpeg{Path: config.FFmpeg},
-		outputPath:  config.Output,
-		rateLimiter: rate.NewLimiter(rate.Every(time.Second), 1),
-		client:      b,
+func newFFmpeg(config *Config) FFmpeg {
+	ffprobePath := config.FFprobe
+	if ffprobePath == "" {
+		ffprobePath = defaultFFprobePath(config.FFmpeg)
 	}
+	return FFmpeg{Path: config.FFmpeg, FFprobe: ffprobePath, Verify: config.Verify}
+}
+
+// NewDownloaderFromConfig creates the downloader of config, see
+// newDownloaderFromConfig.
+func NewDownloaderFromConfig(config *Config) (*Downloader, error) {
+	return newDownloaderFromConfig(config, "")
 }
 
-func newDownloader(configPath string) (*Downloader, error) {
-	config, err := LoadConfig(configPath)
+// newDownloaderFromConfig creates the downloader of config with the ffmpeg of
+// ffmpegFlag when it's set, see resolveConfigFFmpeg.
+func newDownloaderFromConfig(config *Config, ffmpegFlag string) (*Downloader, error) {
+	ffmpeg, err := resolveConfigFFmpeg(config, ffmpegFlag)
 	if err != nil {
 		return nil, err
 	}
-	if config.Cookies == "" {
-		return nil, errors.New("please login first")
-	}
-	d := &Downloader{
-		configPath: configPath,
-		config:     config,
+
+	fileNamer, err := newFileNamer(config.OutputTemplate, config.MaxFileNameLength)
+	if err != nil {
+		return nil, err
 	}
 
-	history, err := NewHistory(config.HistoryDB)
+	err = os.MkdirAll(config.Output, 0755)
 	if err != nil {
 		return nil, err
 	}
-	d.history = history
 
-	ffmpegPath := config.FFmpeg
-	_, err = os.Stat(ffmpegPath)
+	history, err := NewHistory(config.HistoryDriver, config.HistoryDB)
 	if err != nil {
-		return nil, errors.Wrap(err, "ffmpeg not exist, please install ffmpeg first")
+		return nil, err
 	}
-	d.ffmpeg = FFmpeg{Path: ffmpegPath}
 
-	outputPath := config.Output
-	_, err = os.Stat(outputPath)
-	if err != nil && os.IsNotExist(err) {
-		err = os.Mkdir(outputPath, 0755)
+	b := bilibili.New()
+	b.SetCookiesString(config.Cookies)
+	enableWbiSigning(b)
+	d := &Downloader{
+		config:       config,
+		ffmpeg:       ffmpeg,
+		outputPath:   config.Output,
+		history:      history,
+		fileNamer:    fileNamer,
+		rateLimiter:  rate.NewLimiter(rate.Every(time.Second), 1),
+		maxFileSize:  config.MaxFileSize,
+		maxRetries:   config.MaxRetries,
+		container:    config.Container,
+		codec:        config.Codec,
+		audioQuality: config.AudioQuality,
+		compat:       config.Compat,
+		compatCRF:    config.CompatCRF,
+		client:       b,
+
+		maxTotalSize: config.MaxTotalSize,
+		minFreeSpace: config.MinFreeSpace,
+		skipExisting: true,
+		keepTemp:     true,
+
+		videoInfoCache: newTTLCache[*bilibili.VideoInfo](config.VideoInfoCacheTTL),
+
+		readTimeout:     config.ReadTimeout,
+		downloadTimeout: config.DownloadTimeout,
+
+		downloadAttempts:      config.DownloadAttempts,
+		downloadRetryInterval: config.DownloadRetryInterval,
+		retries:               config.Retries,
+	}
+	if len(config.Proxies) > 0 {
+		err = d.setProxies(config.Proxies)
 		if err != nil {
 			return nil, err
 		}
 	}
-	d.outputPath = outputPath
+	return d, nil
+}
+
+// configOverrides are flags taking precedence over the config, empty ones are
+// ignored.
+type configOverrides struct {
+	FFmpeg string
+	Output string
+}
 
-	d.client = bilibili.New()
-	d.client.SetCookiesString(config.Cookies)
+// newDownloader loads the downloader of profile from the config at
+// configPath with overrides applied.
+func newDownloader(configPath string, profile string, overrides configOverrides) (*Downloader, error) {
+	rootConfig, err := LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	profileConfig, err := rootConfig.WithProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+	// a copy, so that neither the env nor the overrides are saved to the
+	// root config
+	config := new(Config)
+	*config = *profileConfig
+	if overrides.Output != "" {
+		config.Output = overrides.Output
+	}
+	cookiesFromEnv := false
+	if cookies := normalizeCookies(os.Getenv(CookiesEnv)); cookies != "" {
+		config.Cookies = cookies
+		cookiesFromEnv = true
+	}
 
-	d.rateLimiter = rate.NewLimiter(rate.Every(time.Second), 1)
+	d, err := newDownloaderFromConfig(config, overrides.FFmpeg)
+	if err != nil {
+		return nil, err
+	}
+	// cookies refreshed by the downloader are saved to the root config
+	d.config = rootConfig
+	d.configPath = configPath
+	d.profile = profile
+	d.cookiesFromEnv = cookiesFromEnv
 	return d, nil
 }
 
-func (d *Downloader) GetVideoInfo(bvid string) (*bilibili.VideoInfo, error) {
-	return d.GetClient().GetVideoInfo(bilibili.VideoParam{Bvid: bvid})
+// GetVideoInfo returns the info of bvid, cached for the video info cache TTL
+// so that the steps of a download don't request it again.
+func (d *Downloader) GetVideoInfo(ctx context.Context, bvid string) (*bilibili.VideoInfo, error) {
+	if d.videoInfoCache != nil {
+		if info, ok := d.videoInfoCache.Get(bvid); ok {
+			return info, nil
+		}
+	}
+	info, err := retryClientCall(ctx, d, (*bilibili.Client).GetVideoInfo, bilibili.VideoParam{Bvid: bvid})
+	if err != nil {
+		return nil, err
+	}
+	if d.videoInfoCache != nil {
+		d.videoInfoCache.Set(bvid, info)
+	}
+	return info, nil
 }
 
-func (d *Downloader) GetClient() *bilibili.Client {
-	_ = d.rateLimiter.Wait(context.Background())
-	time.Sleep(time.Duration(rand.IntN(3)+1) * time.Second)
-	return d.client
+func (d *Downloader) GetClient(ctx context.Context) (*bilibili.Client, error) {
+	err := d.rateLimiter.Wait(ctx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	timer := time.NewTimer(time.Duration(rand.IntN(3)+1) * time.Second)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return nil, errors.WithStack(ctx.Err())
+	case <-timer.C:
+	}
+	return d.client, nil
 }
 
 type StreamType string
 
 const (
 	Video StreamType = "video"
-	Audio            = "audio"
+	Audio StreamType = "audio"
+)
+
+const (
+	containerMp4 = "mp4"
+	containerMkv = "mkv"
 )
 
-func getFileName(option DownloadOption, videoOrAudio *bilibili.AudioOrVideo, streamType StreamType) string {
-	if videoOrAudio == nil {
-		return newFileName(option.OwnerName, option.Title, "", "mp4")
+func validateContainer(container string) error {
+	if container != containerMp4 && container != containerMkv {
+		return errors.Newf("invalid container: %s, should be mp4 or mkv", container)
 	}
+	return nil
+}
+
+// getFileName names the temporary stream of option, independently of the
+// output template, which may render the same name for every stream.
+func getFileName(option DownloadOption, streamType StreamType) (string, error) {
 	switch streamType {
-	case Audio:
-		return newFileName(option.OwnerName, option.Title, "audio", videoOrAudio.MimeType)
-	case Video:
-		return newFileName(option.OwnerName, option.Title, "video", videoOrAudio.MimeType)
+	case Audio, Video:
+		return tempFileName(option, string(streamType), "m4s"), nil
 	}
-	panic("invalid arguments")
+	return "", errors.Newf("invalid stream type: %s", streamType)
 }
 
 type DownloadOption struct {
 	Bvid             string
 	Cid              int
+	EpId             int
 	OwnerName        string
 	Title            string
+	Duration         time.Duration
 	SearchKeyword    string
+	Folder           string
 	Tags             []string
 	DownloadProgress string
+	// Interactive is set for interactive videos, whose branches are parts
+	// not listed in pages
+	Interactive bool
+	// Disambiguate appends the bvid to the title in file names, set when the
+	// name is taken by another video
+	Disambiguate bool
+	// OutputName replaces the output template if set, the suffix and
+	// extension are still appended
+	OutputName string
 }
 
 func fileExists(filePath string) bool {
@@ -137,17 +423,65 @@ func fileExists(filePath string) bool {
 	if os.IsNotExist(err) {
 		return false
 	}
-	slog.Error("failed to check if file exists", zap.String("filePath", filePath))
+	zap.L().Error("Failed to check if file exists", zap.String("filePath", filePath), zap.Error(err))
 	return false
 }
 
-func (d *Downloader) Download(option DownloadOption, force bool, saveHistory bool) error {
+// IsDownloaded checks history unless overwrite is set. Videos found restricted
+// recently count as downloaded too. Callers skipping the video count it in
+// alreadyDownloaded.
+func (d *Downloader) IsDownloaded(bvid string, cid int) (bool, error) {
+	if d.overwrite {
+		return false, nil
+	}
+	ok, err := d.history.IsDownloadedKind(bvid, cid, d.historyKind())
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		return true, nil
+	}
+
+	// restricted videos are not retried every run
+	ok, err = d.history.IsRestricted(bvid, time.Now().Add(-restrictedRecheckInterval))
+	if ok {
+		zap.L().Info("Skip video restricted when checked recently", zap.String("bvid", bvid))
+	}
+	return ok, err
+}
+
+// historyKind is the kind of downloads of d in history.
+func (d *Downloader) historyKind() string {
+	if d.audioOnly {
+		return historyKindAudio
+	}
+	return historyKindVideo
+}
+
+// recordRestricted remembers that bvid is restricted so that batches skip it
+// for a while.
+func (d *Downloader) recordRestricted(bvid string, err error) {
+	saveErr := d.history.SaveRestricted(bvid, err.Error())
+	if saveErr != nil {
+		zap.L().Warn("Save restricted video failed", zap.String("bvid", bvid), zap.Error(saveErr))
+	}
+}
+
+func (d *Downloader) getVideoStream(ctx context.Context, option DownloadOption) (*bilibili.VideoStream, error) {
+	if option.EpId != 0 {
+		return d.GetBangumiVideoStream(ctx, option)
+	}
+	return retryClientCall(ctx, d, (*bilibili.Client).GetVideoStream, NewGetVideoStreamParam(option.Bvid, option.Cid))
+}
+
+func (d *Downloader) Download(ctx context.Context, option DownloadOption, force bool, saveHistory bool) error {
 	if !force {
-		ok, err := d.history.IsDownloaded(option.Bvid)
+		ok, err := d.IsDownloaded(option.Bvid, option.Cid)
 		if err != nil {
 			return err
 		}
 		if ok {
+			d.alreadyDownloaded.Add(1)
 			zap.L().Info("Already downloaded", zap.String("bvid", option.Bvid),
 				zap.String("owner", option.OwnerName), zap.String("title", option.Title))
 			return nil
@@ -158,83 +492,409 @@ func (d *Downloader) Download(option DownloadOption, force bool, saveHistory boo
 
 	if option.Cid == 0 {
 		var videoInfo *bilibili.VideoInfo
-		videoInfo, err = d.GetClient().GetVideoInfo(bilibili.VideoParam{Bvid: option.Bvid})
+		videoInfo, err = d.GetVideoInfo(ctx, option.Bvid)
+		if err != nil {
+			return err
+		}
+		err = checkPublished(videoInfo, time.Now())
 		if err != nil {
+			zap.L().Info("Skip premiere not yet published", zap.String("bvid", option.Bvid),
+				zap.String("title", option.Title), zap.Error(err))
 			return err
 		}
 		option.Cid = videoInfo.Cid
+		option.Interactive = videoInfo.Rights.IsSteinGate != 0
+	}
+	if option.Interactive {
+		if d.allBranches {
+			return d.downloadBranches(ctx, option, saveHistory)
+		}
+		zap.L().Info("Interactive video, only the first branch is downloaded unless --all-branches is set",
+			zap.String("bvid", option.Bvid), zap.String("title", option.Title))
 	}
 
-	result, err := d.GetClient().GetVideoStream(NewGetVideoStreamParam(option.Bvid, option.Cid))
+	result, err := d.getVideoStream(ctx, option)
 	if err != nil {
+		err = wrapNotAvailable(err)
+		if errors.Is(err, ErrNotAvailable) {
+			zap.L().Info("Video unavailable", zap.String("bvid", option.Bvid),
+				zap.String("title", option.Title), zap.Error(err))
+			d.recordRestricted(option.Bvid, err)
+		}
 		return errors.Wrapf(err, "get video stream, bvid: %s, cid: %d", option.Bvid, option.Cid)
 	}
-	if len(result.Dash.Video) == 0 || len(result.Dash.Audio) == 0 {
-		if result.Result == "suee" {
-			zap.L().Info("Not available streams", zap.String("bvid", option.Bvid))
-			return nil
+	hasDash := len(result.Dash.Video) > 0 && len(result.Dash.Audio) > 0
+	if !hasDash && len(result.Durl) == 0 {
+		if result.Result == playurlResultSuccess {
+			// the request succeeded but no stream is playable, which happens
+			// to region or VIP locked videos
+			zap.L().Info("Video unavailable (region/vip locked)", zap.String("bvid", option.Bvid),
+				zap.String("title", option.Title))
+			err = errors.Wrapf(ErrNotAvailable, "no playable stream, bvid: %s", option.Bvid)
+			d.recordRestricted(option.Bvid, err)
+			return err
 		}
-		return errors.Newf("can't get video stream, bvid: %s", option.Bvid)
-	}
-
-	slices.SortFunc(result.Dash.Video, func(a, b bilibili.AudioOrVideo) int { return b.Bandwidth - a.Bandwidth })
-	slices.SortFunc(result.Dash.Audio, func(a, b bilibili.AudioOrVideo) int { return b.Bandwidth - a.Bandwidth })
-
-	outputFile := getFileName(option, nil, Video)
-	dstFilePath := filepath.Join(d.outputPath, outputFile)
-	if fileExists(dstFilePath) {
-		slog.Info("Skip download", "fileName", outputFile)
-		return nil
+		return errors.Newf("can't get video stream, bvid: %s, result: %s", option.Bvid, result.Result)
 	}
 
-	video := result.Dash.Video[0]
-	videoPath := filepath.Join(d.outputPath, newFileName(option.OwnerName, option.Title, "video", video.MimeType))
-
-	err = d.DownloadFile(videoPath, append([]string{video.BaseUrl}, video.BackupUrl...))
+	outputFile, err := d.outputFile(&option)
 	if err != nil {
 		return err
 	}
-
-	audio := result.Dash.Audio[0]
-	audioPath := filepath.Join(d.outputPath, newFileName(option.OwnerName, option.Title, "audio", audio.MimeType))
-
-	err = d.DownloadFile(audioPath, append([]string{audio.BaseUrl}, audio.BackupUrl...))
+	dstFilePath := filepath.Join(d.outputPath, outputFile)
+	if fileExists(dstFilePath) {
+		if d.skipExisting && !d.overwrite {
+			slog.Info("Skip download", "fileName", outputFile)
+			d.alreadyDownloaded.Add(1)
+			return nil
+		}
+		zap.L().Info("Overwrite existing file", zap.String("file", outputFile))
+		err = os.Remove(dstFilePath)
+		if err != nil {
+			return err
+		}
+	}
+	err = os.MkdirAll(filepath.Dir(dstFilePath), 0755)
 	if err != nil {
 		return err
 	}
 
-	if option.DownloadProgress != "" {
-		fmt.Printf("%s Merging %s\n", option.DownloadProgress, outputFile)
+	if d.audioOnly {
+		err = d.downloadAudio(ctx, option, result, outputFile, dstFilePath)
+		if err != nil {
+			return err
+		}
+	} else if hasDash {
+		err = d.downloadDash(ctx, option, result, outputFile, dstFilePath)
+		if err != nil {
+			return err
+		}
 	} else {
-		fmt.Printf("Merging %s\n", outputFile)
+		err = d.downloadDurl(ctx, option, result, outputFile, dstFilePath)
+		if err != nil {
+			return err
+		}
 	}
 
 	ffmpeg := d.ffmpeg
-	err = ffmpeg.MergeVideoAudio(videoPath, audioPath, filepath.Join(d.outputPath, outputFile))
-	if err != nil {
-		zap.L().Error("Merge failed", zap.Error(err), zap.String("file", outputFile))
-		return nil
+	if ffmpeg.Verify {
+		if d.audioOnly {
+			err = ffmpeg.VerifyAudioFile(ctx, dstFilePath)
+		} else {
+			err = ffmpeg.VerifyFile(ctx, dstFilePath)
+		}
+		if err != nil {
+			// remove the corrupt output so the next run downloads it again
+			_ = os.Remove(dstFilePath)
+			return errors.Wrapf(err, "verify %s", outputFile)
+		}
 	}
+	d.writeSidecars(ctx, option, dstFilePath)
 
-	_ = os.Remove(videoPath)
-	_ = os.Remove(audioPath)
+	var fileSize int64
+	fi, err := os.Stat(dstFilePath)
+	if err != nil {
+		zap.L().Warn("Stat output file failed", zap.String("file", outputFile), zap.Error(err))
+	} else {
+		fileSize = fi.Size()
+	}
+	d.totalSize.Add(fileSize)
 
 	if saveHistory {
-		return d.history.Save(&HistoryEntry{
+		entry := &HistoryEntry{
 			Bvid:     option.Bvid,
+			Cid:      option.Cid,
+			Kind:     d.historyKind(),
 			Author:   option.OwnerName,
 			Title:    option.Title,
 			Keyword:  option.SearchKeyword,
-			Tags:     strings.Join(option.Tags, ";"),
+			Folder:   option.Folder,
 			FileName: outputFile,
+			FileSize: fileSize,
+
+			DownloadedAt: time.Now(),
+		}
+		entry.SetTags(option.Tags)
+		return d.history.Save(entry)
+	}
+
+	return nil
+}
+
+// outputFile names the merged file of option, relative to the output
+// directory. A name taken by another video, in history, in this run or by an
+// unknown file on disk, gets the bvid appended, so that videos with the same
+// title, e.g. "无标题", don't overwrite or skip each other.
+func (d *Downloader) outputFile(option *DownloadOption) (string, error) {
+	name, err := d.fileNamer.newFileName(*option, "", d.outputExt())
+	if err != nil {
+		return "", err
+	}
+	dir, err := groupDir(d.groupBy, *option, time.Now())
+	if err != nil {
+		return "", err
+	}
+	outputFile := filepath.Join(dir, name)
+	if option.Disambiguate || option.OutputName != "" {
+		d.reserveName(outputFile, option.Bvid)
+		return outputFile, nil
+	}
+
+	d.reservedNamesMu.Lock()
+	defer d.reservedNamesMu.Unlock()
+	owner, ok := d.reservedNames[outputFile]
+	if !ok {
+		owner, err = d.history.FileOwner(outputFile)
+		if err != nil {
+			return "", err
+		}
+		if owner == "" && fileExists(filepath.Join(d.outputPath, outputFile)) {
+			owner = "unknown file"
+		}
+	}
+	if owner == "" || owner == option.Bvid {
+		d.reserveNameLocked(outputFile, option.Bvid)
+		return outputFile, nil
+	}
+	zap.L().Info("File name is taken by another video, append the bvid", zap.String("file", outputFile),
+		zap.String("bvid", option.Bvid), zap.String("owner", owner))
+	option.Disambiguate = true
+	name, err = d.fileNamer.newFileName(*option, "", d.outputExt())
+	if err != nil {
+		return "", err
+	}
+	outputFile = filepath.Join(dir, name)
+	d.reserveNameLocked(outputFile, option.Bvid)
+	return outputFile, nil
+}
+
+func (d *Downloader) reserveName(outputFile string, bvid string) {
+	d.reservedNamesMu.Lock()
+	defer d.reservedNamesMu.Unlock()
+	d.reserveNameLocked(outputFile, bvid)
+}
+
+func (d *Downloader) reserveNameLocked(outputFile string, bvid string) {
+	if d.reservedNames == nil {
+		d.reservedNames = make(map[string]string)
+	}
+	d.reservedNames[outputFile] = bvid
+}
+
+// outputExt is the extension of the merged file, the audio format for
+// --audio-only.
+func (d *Downloader) outputExt() string {
+	if !d.audioOnly {
+		return d.container
+	}
+	if d.extractAudioFormat == audioFormatNative {
+		return audioFormatM4a
+	}
+	return d.extractAudioFormat
+}
+
+// ErrMergeFailed marks errors of merging downloaded streams.
+var ErrMergeFailed = errors.New("merge failed")
+
+// downloadDash downloads the video and audio streams and merges them into
+// dstFilePath. Streams kept by a failed merge are merged again instead of
+// being downloaded.
+func (d *Downloader) downloadDash(ctx context.Context, option DownloadOption, result *bilibili.VideoStream,
+	outputFile string, dstFilePath string) error {
+	sortByBandwidth(result.Dash.Video)
+	sortByBandwidth(result.Dash.Audio)
+
+	video, ok := selectVideoStream(result.Dash.Video, d.codec)
+	if !ok {
+		zap.L().Info("Preferred codec not available, use another one", zap.String("bvid", option.Bvid),
+			zap.String("codec", d.codec), zap.String("codecs", video.Codecs))
+	}
+	audio, ok := selectAudioStream(&result.Dash, d.audioQuality)
+	if !ok {
+		zap.L().Info("Preferred audio quality not available, use another one", zap.String("bvid", option.Bvid),
+			zap.String("audioQuality", d.audioQuality), zap.Int("quality", audio.Id))
+	}
+	zap.L().Debug("Selected streams", zap.String("bvid", option.Bvid),
+		zap.Int("videoQuality", video.Id), zap.String("videoCodecs", video.Codecs),
+		zap.Int("videoBandwidth", video.Bandwidth), zap.String("videoUrl", video.BaseUrl),
+		zap.Int("audioQuality", audio.Id), zap.Int("audioBandwidth", audio.Bandwidth),
+		zap.String("audioUrl", audio.BaseUrl))
+
+	videoFile, err := getFileName(option, Video)
+	if err != nil {
+		return err
+	}
+	videoPath := filepath.Join(d.outputPath, videoFile)
+	audioFile, err := getFileName(option, Audio)
+	if err != nil {
+		return err
+	}
+	audioPath := filepath.Join(d.outputPath, audioFile)
+	removeStreams := func() {
+		_ = os.Remove(videoPath)
+		_ = os.Remove(audioPath)
+	}
+
+	// the streams are renamed to their final names only when they are
+	// complete, so existing ones are left by a failed merge
+	kept := fileExists(videoPath) && fileExists(audioPath)
+	if kept {
+		zap.L().Info("Streams of a failed merge found, merge them again", zap.String("bvid", option.Bvid),
+			zap.String("file", outputFile))
+	} else {
+		err = d.checkFreeSpace(estimateDownloadSize(option.Duration, video, audio))
+		if err != nil {
+			return err
+		}
+
+		// the streams are independent, only the video shows a progress bar so
+		// that the two bars don't overwrite each other
+		g, gctx := errgroup.WithContext(ctx)
+		g.Go(func() error {
+			return d.downloadFile(gctx, videoPath, append([]string{video.BaseUrl}, video.BackupUrl...), true)
 		})
+		g.Go(func() error {
+			return d.downloadFile(gctx, audioPath, append([]string{audio.BaseUrl}, audio.BackupUrl...), false)
+		})
+		err = g.Wait()
+		if err != nil {
+			removeStreams()
+			return err
+		}
+	}
+
+	if d.compat && matchCodec(&video, codecAV1) {
+		zap.L().Warn("Transcoding AV1 to H.264 for compatibility, this is CPU intensive and much slower than merging",
+			zap.String("bvid", option.Bvid), zap.Int("crf", d.compatCRF))
+		printProgress(option, "Transcoding", outputFile)
+		err = d.ffmpeg.TranscodeVideoAudio(ctx, videoPath, audioPath, dstFilePath, d.compatCRF)
+	} else {
+		printProgress(option, "Merging", outputFile)
+		err = d.ffmpeg.MergeVideoAudio(ctx, videoPath, audioPath, dstFilePath)
+	}
+	if err != nil {
+		_ = os.Remove(dstFilePath)
+		if ctx.Err() != nil {
+			removeStreams()
+			return err
+		}
+		// streams failing to merge twice or reported corrupt are likely
+		// broken, download them again next time
+		if kept || !d.keepTemp || errors.Is(err, ErrFFmpegCorruptInput) {
+			removeStreams()
+		}
+		return errors.Mark(errors.Wrapf(err, "merge %s", outputFile), ErrMergeFailed)
+	}
+
+	removeStreams()
+	return nil
+}
+
+// downloadAudio downloads the audio stream and extracts it into dstFilePath
+// in the audio format.
+func (d *Downloader) downloadAudio(ctx context.Context, option DownloadOption, result *bilibili.VideoStream,
+	outputFile string, dstFilePath string) error {
+	if len(result.Dash.Audio) == 0 {
+		return errors.Newf("no separate audio stream, bvid: %s", option.Bvid)
+	}
+	sortByBandwidth(result.Dash.Audio)
+	audio, ok := selectAudioStream(&result.Dash, d.audioQuality)
+	if !ok {
+		zap.L().Info("Preferred audio quality not available, use another one", zap.String("bvid", option.Bvid),
+			zap.String("audioQuality", d.audioQuality), zap.Int("quality", audio.Id))
+	}
+
+	audioFile, err := getFileName(option, Audio)
+	if err != nil {
+		return err
+	}
+	audioPath := filepath.Join(d.outputPath, audioFile)
+	err = d.checkFreeSpace(estimateDownloadSize(option.Duration, audio))
+	if err != nil {
+		return err
+	}
+	err = d.downloadFile(ctx, audioPath, append([]string{audio.BaseUrl}, audio.BackupUrl...), true)
+	if err != nil {
+		return err
 	}
+	defer func() { _ = os.Remove(audioPath) }()
 
+	printProgress(option, "Extracting audio", outputFile)
+	err = d.ffmpeg.ExtractAudio(ctx, audioPath, dstFilePath, d.extractAudioFormat, d.audioBitrate)
+	if err != nil {
+		_ = os.Remove(dstFilePath)
+		return errors.Mark(errors.Wrapf(err, "extract audio %s", outputFile), ErrMergeFailed)
+	}
+	return nil
+}
+
+// downloadDurl downloads videos served as muxed segments (durl) instead of
+// separate dash streams, used by old videos. The segments are remuxed, or
+// concatenated if there are several, into dstFilePath.
+func (d *Downloader) downloadDurl(ctx context.Context, option DownloadOption, result *bilibili.VideoStream,
+	outputFile string, dstFilePath string) error {
+	var size uint64
+	for _, durl := range result.Durl {
+		size += uint64(durl.Size)
+	}
+	err := d.checkFreeSpace(size)
+	if err != nil {
+		return err
+	}
+
+	segmentPaths := make([]string, 0, len(result.Durl))
+	defer func() {
+		for _, p := range segmentPaths {
+			_ = os.Remove(p)
+		}
+	}()
+
+	for i, durl := range result.Durl {
+		segmentFile := tempFileName(option, fmt.Sprintf("part%d", i+1), result.Format)
+		segmentPath := filepath.Join(d.outputPath, segmentFile)
+		segmentPaths = append(segmentPaths, segmentPath)
+
+		err = d.DownloadFile(ctx, segmentPath, append([]string{durl.Url}, durl.BackupUrl...))
+		if err != nil {
+			return err
+		}
+	}
+
+	printProgress(option, "Remuxing", outputFile)
+
+	err = d.ffmpeg.Remux(ctx, segmentPaths, dstFilePath)
+	if err != nil {
+		_ = os.Remove(dstFilePath)
+		return errors.Wrapf(err, "remux %s", outputFile)
+	}
 	return nil
 }
 
+func printProgress(option DownloadOption, action string, fileName string) {
+	if option.DownloadProgress != "" {
+		_, _ = fmt.Fprintf(barWriter, "%s %s %s\n", option.DownloadProgress, action, fileName)
+	} else {
+		_, _ = fmt.Fprintf(barWriter, "%s %s\n", action, fileName)
+	}
+}
+
+// totalSizeExceeded reports whether merged files of this run have used up
+// the total size budget.
+func (d *Downloader) totalSizeExceeded() bool {
+	return d.maxTotalSize > 0 && d.totalSize.Load() >= d.maxTotalSize
+}
+
+// SaveConfig writes back the cookies of the client, which may have been
+// refreshed by the server during the session.
 func (d *Downloader) SaveConfig() error {
+	if d.cookiesFromEnv {
+		return nil
+	}
 	cookies := d.client.GetCookiesString()
-	d.config.Cookies = cookies
+	if cookies == "" {
+		zap.L().Warn("Cookies are empty, skip saving config")
+		return nil
+	}
+	d.config.SetCookies(d.profile, cookies)
 	return SaveConfig(d.configPath, d.config)
 }
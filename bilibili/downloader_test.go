@@ -0,0 +1,467 @@
+package bilibili
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/go-resty/resty/v2"
+	"golang.org/x/time/rate"
+
+	"github.com/CuteReimu/bilibili/v2"
+)
+
+func TestNewDownloaderFromConfig(t *testing.T) {
+	d := newTestDownloader(t, func(config *Config) {
+		config.MaxFileSize = 1 << 20
+	})
+	if d.maxFileSize != 1<<20 {
+		t.Fatalf("maxFileSize = %d, want %d", d.maxFileSize, 1<<20)
+	}
+
+	err := d.history.Save(&HistoryEntry{Bvid: "BV1y7411Q7Eq"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = d.Download(context.Background(), DownloadOption{Bvid: "BV1y7411Q7Eq"}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetClientCanceled(t *testing.T) {
+	d := &Downloader{rateLimiter: rate.NewLimiter(rate.Every(time.Hour), 1)}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	_, err := d.GetClient(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("GetClient() = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("GetClient() took %s after ctx is canceled", elapsed)
+	}
+}
+
+func TestOutputFileReserved(t *testing.T) {
+	d := newTestDownloader(t)
+	dir := d.outputPath
+
+	a := DownloadOption{Bvid: "BV1y7411Q7Eq", OwnerName: "author", Title: "无标题"}
+	b := DownloadOption{Bvid: "BV17x411w7KC", OwnerName: "author", Title: "无标题"}
+	nameA, err := d.outputFile(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nameB, err := d.outputFile(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nameA == nameB {
+		t.Errorf("videos of one run share the file name %q", nameA)
+	}
+	again, err := d.outputFile(&DownloadOption{Bvid: "BV1y7411Q7Eq", OwnerName: "author", Title: "无标题"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != nameA {
+		t.Errorf("outputFile() of the same video = %q, want %q", again, nameA)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "author - 其他.mp4"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := d.outputFile(&DownloadOption{Bvid: "BV1Q541167Qg", OwnerName: "author", Title: "其他"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "author - 其他 [BV1Q541167Qg].mp4"; name != want {
+		t.Errorf("outputFile() over an unknown file = %q, want %q", name, want)
+	}
+}
+
+func TestDefaultFFprobePath(t *testing.T) {
+	ext := defaultExecutableFileExtension()
+	for _, test := range []struct {
+		ffmpeg string
+		want   string
+	}{
+		{ffmpeg: "ffmpeg" + ext, want: "ffprobe" + ext},
+		{ffmpeg: filepath.Join("bin", "ffmpeg"+ext), want: filepath.Join("bin", "ffprobe"+ext)},
+	} {
+		got := defaultFFprobePath(test.ffmpeg)
+		if got != test.want {
+			t.Errorf("defaultFFprobePath(%q) = %q, want %q", test.ffmpeg, got, test.want)
+		}
+	}
+}
+
+func TestDownloadFileHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Referer") != downloadReferer || r.Header.Get("User-Agent") != downloadUserAgent {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte("content"))
+	}))
+	defer server.Close()
+
+	d := newTestDownloader(t, func(config *Config) {
+		config.DownloadAttempts = 1
+	})
+	dir := d.outputPath
+	d.downloadClient = resty.New()
+
+	filePath := filepath.Join(dir, "file")
+	err := d.DownloadFile(context.Background(), filePath, []string{server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "content" {
+		t.Errorf("content = %q, want %q", buf, "content")
+	}
+}
+
+func TestGetFileName(t *testing.T) {
+	option := DownloadOption{Bvid: "BV1y7411Q7Eq", Cid: 100, OwnerName: "a", Title: "t"}
+	for _, test := range []struct {
+		streamType StreamType
+		want       string
+	}{
+		{streamType: Video, want: "BV1y7411Q7Eq_100.video.m4s"},
+		{streamType: Audio, want: "BV1y7411Q7Eq_100.audio.m4s"},
+	} {
+		got, err := getFileName(option, test.streamType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("getFileName(%s) = %q, want %q", test.streamType, got, test.want)
+		}
+	}
+
+	_, err := getFileName(option, StreamType("subtitle"))
+	if err == nil {
+		t.Error("expected error of invalid stream type")
+	}
+}
+
+func TestResolveFFmpeg(t *testing.T) {
+	dir := t.TempDir()
+	ffmpegPath := filepath.Join(dir, "ffmpeg"+defaultExecutableFileExtension())
+	err := os.WriteFile(ffmpegPath, []byte{}, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := resolveFFmpeg(ffmpegPath)
+	if err != nil || got != ffmpegPath {
+		t.Errorf("resolveFFmpeg(%q) = %q, %v", ffmpegPath, got, err)
+	}
+
+	t.Setenv("PATH", dir)
+	got, err = resolveFFmpeg(filepath.Join(dir, "missing", "ffmpeg"))
+	if err != nil || got != ffmpegPath {
+		t.Errorf("resolveFFmpeg(missing) = %q, %v, want %q", got, err, ffmpegPath)
+	}
+
+	t.Setenv("PATH", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	_, err = resolveFFmpeg(filepath.Join(dir, "missing", "ffmpeg"))
+	if err == nil {
+		t.Error("expected error when ffmpeg is nowhere")
+	}
+}
+
+func TestResolveConfigFFmpeg(t *testing.T) {
+	dir := t.TempDir()
+	flagPath := filepath.Join(dir, "flag-ffmpeg")
+	config := defaultConfig()
+	config.FFmpeg = filepath.Join(dir, "config-ffmpeg")
+	for _, p := range []string{flagPath, config.FFmpeg} {
+		err := os.WriteFile(p, []byte{}, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		flag string
+		want string
+	}{
+		{flag: flagPath, want: flagPath},
+		{flag: "", want: config.FFmpeg},
+	} {
+		f, err := resolveConfigFFmpeg(config, test.flag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Path != test.want || f.FFprobe != filepath.Join(dir, "ffprobe"+defaultExecutableFileExtension()) {
+			t.Errorf("resolveConfigFFmpeg(%q) = %+v, want %s", test.flag, f, test.want)
+		}
+	}
+
+	_, err := resolveConfigFFmpeg(config, filepath.Join(dir, "missing"))
+	if err == nil {
+		t.Error("resolveConfigFFmpeg of a missing --ffmpeg succeeded")
+	}
+}
+
+// writeFakeFFmpeg writes an empty ffmpeg to dir for downloaders which don't
+// run it.
+func writeFakeFFmpeg(t *testing.T, dir string) string {
+	p := filepath.Join(dir, "ffmpeg"+defaultExecutableFileExtension())
+	err := os.WriteFile(p, []byte{}, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestDownloadDashRetryMerge(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		_, _ = w.Write([]byte("stream"))
+	}))
+	defer server.Close()
+
+	d := newTestDownloader(t)
+	dir := d.outputPath
+	d.downloadClient = resty.New()
+
+	failing := filepath.Join(dir, "ffmpeg-failing")
+	merging := filepath.Join(dir, "ffmpeg-merging")
+	for p, script := range map[string]string{
+		failing: "#!/bin/sh\necho 'Could not write header for output file #0' >&2\nexit 1\n",
+		merging: "#!/bin/sh\nfor last; do :; done\necho merged > \"$last\"\n",
+	} {
+		err := os.WriteFile(p, []byte(script), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	option := DownloadOption{Bvid: "BV1y7411Q7Eq", OwnerName: "a", Title: "t"}
+	result := &bilibili.VideoStream{}
+	result.Dash.Video = []bilibili.AudioOrVideo{{Id: 80, BaseUrl: server.URL, MimeType: "video/mp4"}}
+	result.Dash.Audio = []bilibili.AudioOrVideo{{Id: 30280, BaseUrl: server.URL, MimeType: "audio/mp4"}}
+	dstFilePath := filepath.Join(dir, "a - t.mp4")
+	streams := func() int {
+		matches, _ := filepath.Glob(filepath.Join(dir, "BV1y7411Q7Eq_*"))
+		return len(matches)
+	}
+
+	d.ffmpeg.Path = failing
+	err := d.downloadDash(context.Background(), option, result, "a - t.mp4", dstFilePath)
+	if !errors.Is(err, ErrMergeFailed) {
+		t.Fatalf("downloadDash with failing ffmpeg = %v, want ErrMergeFailed", err)
+	}
+	if streams() != 2 || requests.Load() != 2 {
+		t.Fatalf("got %d streams kept after %d requests, want 2 after 2", streams(), requests.Load())
+	}
+
+	d.ffmpeg.Path = merging
+	err = d.downloadDash(context.Background(), option, result, "a - t.mp4", dstFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests.Load() != 2 {
+		t.Errorf("kept streams downloaded again, %d requests", requests.Load())
+	}
+	if streams() != 0 || !fileExists(dstFilePath) {
+		t.Errorf("got %d streams left, merged file exists: %v", streams(), fileExists(dstFilePath))
+	}
+}
+
+func TestDownloadDurlSegments(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+
+	ffmpegPath := filepath.Join(t.TempDir(), "ffmpeg")
+	// the fake ffmpeg writes the concat list to the output file
+	err := os.WriteFile(ffmpegPath, []byte("#!/bin/sh\nfor last; do :; done\ncat \"$last.txt\" > \"$last\"\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newTestDownloader(t, func(config *Config) {
+		// a template without the suffix renders the same name for every segment
+		config.OutputTemplate = "{{.Title}}.{{.Ext}}"
+		config.FFmpeg = ffmpegPath
+	})
+	dir := d.outputPath
+	d.downloadClient = resty.New()
+
+	option := DownloadOption{Bvid: "BV1y7411Q7Eq", Cid: 100, OwnerName: "a", Title: "t"}
+	result := &bilibili.VideoStream{Format: "flv720"}
+	result.Durl = []bilibili.Durl{{Url: server.URL + "/1"}, {Url: server.URL + "/2"}}
+	dstFilePath := filepath.Join(dir, "t.mp4")
+	err = d.downloadDurl(context.Background(), option, result, "t.mp4", dstFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := os.ReadFile(dstFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, segment := range []string{"BV1y7411Q7Eq_100.part1.flv", "BV1y7411Q7Eq_100.part2.flv"} {
+		if !strings.Contains(string(list), segment) {
+			t.Errorf("concat list doesn't have %s:\n%s", segment, list)
+		}
+	}
+}
+
+func TestDownloadDashCompat(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("stream"))
+	}))
+	defer server.Close()
+
+	ffmpegPath := filepath.Join(t.TempDir(), "ffmpeg")
+	// the fake ffmpeg writes its arguments to the output file
+	err := os.WriteFile(ffmpegPath, []byte("#!/bin/sh\nfor last; do :; done\necho \"$@\" > \"$last\"\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newTestDownloader(t, func(config *Config) {
+		config.FFmpeg = ffmpegPath
+	})
+	dir := d.outputPath
+	d.downloadClient = resty.New()
+	d.compat = true
+
+	option := DownloadOption{Bvid: "BV1y7411Q7Eq", OwnerName: "a", Title: "t"}
+	for _, test := range []struct {
+		codecs    string
+		transcode bool
+	}{
+		{codecs: "av01.0.08M.08.0.110.01.01.01.0", transcode: true},
+		{codecs: "avc1.640032", transcode: false},
+	} {
+		result := &bilibili.VideoStream{}
+		result.Dash.Video = []bilibili.AudioOrVideo{{Id: 80, Codecs: test.codecs, BaseUrl: server.URL, MimeType: "video/mp4"}}
+		result.Dash.Audio = []bilibili.AudioOrVideo{{Id: 30280, BaseUrl: server.URL, MimeType: "audio/mp4"}}
+		dstFilePath := filepath.Join(dir, "a - t.mp4")
+		err = d.downloadDash(context.Background(), option, result, "a - t.mp4", dstFilePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		args, err := os.ReadFile(dstFilePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Contains(string(args), "libx264"); got != test.transcode {
+			t.Errorf("%s transcoded = %v, want %v, args: %s", test.codecs, got, test.transcode, args)
+		}
+	}
+}
+
+func TestNewDownloaderCookiesFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	ffmpegPath := filepath.Join(dir, "ffmpeg")
+	err := os.WriteFile(ffmpegPath, []byte{}, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "config.yml")
+	configText := "output: " + dir + "\nhistory_db: " + filepath.Join(dir, "history.db") + "\nffmpeg: " + ffmpegPath + "\n"
+	err = os.WriteFile(configPath, []byte(configText), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(CookiesEnv, "")
+	d, err := newDownloader(configPath, "", configOverrides{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.ensureLogin(false)
+	if !errors.Is(err, ErrNotLoggedIn) {
+		t.Fatalf("ensureLogin() without cookies = %v, want ErrNotLoggedIn", err)
+	}
+
+	t.Setenv(CookiesEnv, " SESSDATA=a; bili_jct=b; ")
+	d, err = newDownloader(configPath, "", configOverrides{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.SaveConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != configText {
+		t.Errorf("cookies from env are written to the config:\n%s", buf)
+	}
+}
+
+func TestNewDownloaderOverrides(t *testing.T) {
+	dir := t.TempDir()
+	ffmpegPath := filepath.Join(dir, "ffmpeg")
+	err := os.WriteFile(ffmpegPath, []byte{}, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "config.yml")
+	configText := "cookies: SESSDATA=a\noutput: " + filepath.Join(dir, "config-output") + "\nhistory_db: " +
+		filepath.Join(dir, "history.db") + "\nffmpeg: " + filepath.Join(dir, "missing") + "\n"
+	err = os.WriteFile(configPath, []byte(configText), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(dir, "flag", "output")
+	d, err := newDownloader(configPath, "", configOverrides{FFmpeg: ffmpegPath, Output: output})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.outputPath != output || !fileExists(output) {
+		t.Errorf("output = %s, want %s created", d.outputPath, output)
+	}
+	if d.ffmpeg.Path != ffmpegPath {
+		t.Errorf("ffmpeg = %s, want %s", d.ffmpeg.Path, ffmpegPath)
+	}
+
+	err = d.SaveConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Output != filepath.Join(dir, "config-output") {
+		t.Errorf("--output is saved to the config: %s", config.Output)
+	}
+}
@@ -0,0 +1,151 @@
+package bilibili
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/urfave/cli/v3"
+	"go.uber.org/zap"
+)
+
+const favResourcesPageSize = 20
+
+// favMediaTypeVideo is the type of video resources in a favorites folder,
+// other types are audios and collections.
+const favMediaTypeVideo = 2
+
+type FavMedia struct {
+	Id       int    `json:"id"`
+	Type     int    `json:"type"`
+	Title    string `json:"title"`
+	Duration int    `json:"duration"`
+	Attr     int    `json:"attr"`
+	Bvid     string `json:"bvid"`
+	Upper    struct {
+		Mid  int    `json:"mid"`
+		Name string `json:"name"`
+	} `json:"upper"`
+}
+
+type FavResources struct {
+	Info struct {
+		Id         int    `json:"id"`
+		Title      string `json:"title"`
+		MediaCount int    `json:"media_count"`
+	} `json:"info"`
+	Medias  []FavMedia `json:"medias"`
+	HasMore bool       `json:"has_more"`
+}
+
+func (d *Downloader) GetFavResources(ctx context.Context, fid int, page int) (*FavResources, error) {
+	return retryAPI(ctx, d.maxRetries, func() (*FavResources, error) {
+		client, err := d.GetClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return getResponseData[FavResources](client, "https://api.bilibili.com/x/v3/fav/resource/list", map[string]string{
+			"media_id": strconv.Itoa(fid),
+			"pn":       strconv.Itoa(page),
+			"ps":       strconv.Itoa(favResourcesPageSize),
+			"platform": "web",
+		})
+	})
+}
+
+var downloadFavCmd = &cli.Command{
+	Name:  "fav",
+	Usage: "Download videos in a favorites folder",
+	Flags: slices.Concat(
+		[]cli.Flag{
+			&cli.IntFlag{
+				Name:     "fid",
+				Usage:    "Favorites folder ID",
+				Required: true,
+			},
+			&cli.IntFlag{
+				Name:    "max-items",
+				Aliases: []string{"max", "m"},
+				Value:   200,
+			},
+			&cli.IntFlag{
+				Name:  "concurrency",
+				Usage: "Number of videos to download in parallel",
+				Value: 1,
+			},
+		},
+		DownloadFlags(),
+		batchFlags(),
+		notifyFlags(),
+	),
+	Action: func(ctx context.Context, command *cli.Command) error {
+		fid := command.Int("fid")
+		d, err := downloaderFromCliCommand(command)
+		if err != nil {
+			return err
+		}
+
+		maxItems := command.Int("max-items")
+		results := make([]FavMedia, 0)
+		folder := ""
+
+		for page := 1; len(results) < maxItems; page++ {
+			rsp, err := d.GetFavResources(ctx, fid, page)
+			if err != nil {
+				return err
+			}
+			folder = rsp.Info.Title
+			zap.L().Info("Favorites", zap.String("folder", folder),
+				zap.Int("page", page), zap.Int("count", len(rsp.Medias)))
+
+			for _, m := range rsp.Medias {
+				if len(results) >= maxItems {
+					break
+				}
+				if m.Type != favMediaTypeVideo {
+					continue
+				}
+				if m.Attr != 0 {
+					zap.L().Info("Skip invalid video", zap.String("bvid", m.Bvid),
+						zap.String("title", m.Title))
+					continue
+				}
+
+				ok, err := d.IsDownloaded(m.Bvid, 0)
+				if err != nil {
+					return err
+				}
+				if ok {
+					d.alreadyDownloaded.Add(1)
+					continue
+				}
+				results = append(results, m)
+			}
+
+			if !rsp.HasMore {
+				break
+			}
+		}
+
+		zap.L().Info("Favorites completed", zap.String("folder", folder), zap.Int("results", len(results)))
+
+		options := make([]DownloadOption, 0, len(results))
+		for i, m := range results {
+			options = append(options, DownloadOption{
+				Bvid:             m.Bvid,
+				OwnerName:        m.Upper.Name,
+				Title:            m.Title,
+				Folder:           folder,
+				Duration:         time.Duration(m.Duration) * time.Second,
+				DownloadProgress: fmt.Sprintf("(%d/%d)", i+1, len(results)),
+			})
+		}
+
+		if command.Bool("dry-run") {
+			return printCandidates(options)
+		}
+		return d.finishBatch(command, d.DownloadBatch(ctx, options, command.Int("concurrency")))
+	},
+}
@@ -1,20 +1,223 @@
 package bilibili
 
 import (
+	"bytes"
+	"context"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/cockroachdb/errors"
+	"go.uber.org/zap"
 )
 
 type FFmpeg struct {
-	Path string
+	Path    string
+	FFprobe string
+	Verify  bool
 }
 
-func (f *FFmpeg) MergeVideoAudio(videoPath, audioPath, outputPath string) error {
-	cmd := exec.Command(f.Path, "-i", videoPath, "-i", audioPath, "-c:v", "copy", "-c:a", "copy", outputPath)
+// resolveConfigFFmpeg returns the ffmpeg of flag if it's set, or the one of
+// config, falling back to ffmpeg on PATH and then the one installed by
+// install-ffmpeg. ffprobe and verification come from config.
+func resolveConfigFFmpeg(config *Config, flag string) (FFmpeg, error) {
+	c := *config
+	if flag != "" {
+		_, err := os.Stat(flag)
+		if err != nil {
+			return FFmpeg{}, errors.Wrapf(err, "ffmpeg of --ffmpeg not exist: %s", flag)
+		}
+		c.FFmpeg = flag
+	} else {
+		var err error
+		c.FFmpeg, err = resolveFFmpeg(config.FFmpeg)
+		if err != nil {
+			return FFmpeg{}, err
+		}
+	}
+	return newFFmpeg(&c), nil
+}
+
+// resolveFFmpeg returns path if it exists, or ffmpeg on PATH, or the one
+// installed by install-ffmpeg otherwise.
+func resolveFFmpeg(path string) (string, error) {
+	statErr := errors.New("ffmpeg not configured")
+	if path != "" {
+		_, statErr = os.Stat(path)
+		if statErr == nil {
+			return path, nil
+		}
+	}
+	found, err := exec.LookPath("ffmpeg")
+	if err == nil {
+		zap.L().Info("Configured ffmpeg not found, use the one on PATH",
+			zap.String("configured", path), zap.String("path", found))
+		return found, nil
+	}
+	if found, ok := cachedFFmpeg(); ok {
+		zap.L().Info("Configured ffmpeg not found, use the installed one",
+			zap.String("configured", path), zap.String("path", found))
+		return found, nil
+	}
+	return "", errors.Wrap(statErr, "ffmpeg not exist, please install ffmpeg first or run install-ffmpeg")
+}
+
+// defaultFFprobePath returns the ffprobe binary next to ffmpeg.
+func defaultFFprobePath(ffmpegPath string) string {
+	name := "ffprobe" + defaultExecutableFileExtension()
+	dir := filepath.Dir(ffmpegPath)
+	if dir == "." && !strings.ContainsRune(ffmpegPath, filepath.Separator) {
+		return name
+	}
+	return filepath.Join(dir, name)
+}
+
+// resolveFFprobe returns path if it's an executable, or ffprobe on PATH
+// otherwise. It's checked once when verification is on instead of failing
+// every download at the verification step.
+func resolveFFprobe(path string) (string, error) {
+	found, statErr := exec.LookPath(path)
+	if statErr == nil {
+		return found, nil
+	}
+	found, err := exec.LookPath("ffprobe")
+	if err == nil {
+		zap.L().Info("Configured ffprobe not found, use the one on PATH",
+			zap.String("configured", path), zap.String("path", found))
+		return found, nil
+	}
+	return "", errors.Wrap(statErr, "ffprobe not exist, verify needs ffprobe, please set ffprobe in config")
+}
+
+var (
+	// ErrFFmpegInputMissing is returned when an input of ffmpeg doesn't exist.
+	ErrFFmpegInputMissing = errors.New("ffmpeg input missing")
+	// ErrFFmpegCorruptInput is returned when an input of ffmpeg is truncated
+	// or not a media file.
+	ErrFFmpegCorruptInput = errors.New("ffmpeg input corrupt")
+)
+
+// ffmpegErrorLines is how many of the last lines of the output are kept in
+// errors, the full output is logged at debug level.
+const ffmpegErrorLines = 5
+
+// ffmpegError wraps err of ffmpeg with the end of its output, which is where
+// ffmpeg reports the error, and marks the well known failures.
+func ffmpegError(err error, output string) error {
+	zap.L().Debug("ffmpeg failed", zap.String("output", output), zap.Error(err))
+
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	lines = lines[max(len(lines)-ffmpegErrorLines, 0):]
+	err = errors.Wrap(err, strings.TrimSpace(strings.Join(lines, "\n")))
+
+	switch {
+	case strings.Contains(output, "No such file or directory"):
+		return errors.Mark(err, ErrFFmpegInputMissing)
+	case strings.Contains(output, "moov atom not found"), strings.Contains(output, "Invalid data found when processing input"):
+		return errors.Mark(err, ErrFFmpegCorruptInput)
+	}
+	return err
+}
+
+func runFFmpeg(ctx context.Context, path string, args ...string) error {
+	cmd := exec.CommandContext(ctx, path, args...)
 	buf, err := cmd.CombinedOutput()
 	if err != nil {
-		return errors.Wrap(err, string(buf))
+		return ffmpegError(err, string(buf))
+	}
+	return nil
+}
+
+func (f *FFmpeg) MergeVideoAudio(ctx context.Context, videoPath, audioPath, outputPath string) error {
+	return runFFmpeg(ctx, f.Path, "-i", videoPath, "-i", audioPath, "-c:v", "copy", "-c:a", "copy", outputPath)
+}
+
+// TranscodeVideoAudio merges like MergeVideoAudio but encodes the video to
+// H.264 with crf for players without AV1 support.
+func (f *FFmpeg) TranscodeVideoAudio(ctx context.Context, videoPath, audioPath, outputPath string, crf int) error {
+	return runFFmpeg(ctx, f.Path, "-i", videoPath, "-i", audioPath,
+		"-c:v", "libx264", "-crf", strconv.Itoa(crf), "-pix_fmt", "yuv420p", "-c:a", "copy", outputPath)
+}
+
+// ExtractAudio writes the audio of inputPath to outputPath in format, mp3 is
+// encoded at bitrate like 192k, empty means the default of ffmpeg. Other
+// formats than mp3 and flac copy the audio as is.
+func (f *FFmpeg) ExtractAudio(ctx context.Context, inputPath, outputPath, format, bitrate string) error {
+	args := []string{"-i", inputPath, "-vn"}
+	switch format {
+	case audioFormatMp3:
+		args = append(args, "-c:a", "libmp3lame")
+		if bitrate != "" {
+			args = append(args, "-b:a", bitrate)
+		}
+	case audioFormatFlac:
+		args = append(args, "-c:a", "flac")
+	default:
+		args = append(args, "-c:a", "copy")
+	}
+	args = append(args, outputPath)
+	return runFFmpeg(ctx, f.Path, args...)
+}
+
+// Remux copies the streams of inputs into outputPath, several inputs are
+// concatenated in order.
+func (f *FFmpeg) Remux(ctx context.Context, inputs []string, outputPath string) error {
+	args := []string{"-i", inputs[0]}
+	if len(inputs) > 1 {
+		listPath := outputPath + ".txt"
+		var list strings.Builder
+		for _, input := range inputs {
+			abs, err := filepath.Abs(input)
+			if err != nil {
+				return err
+			}
+			list.WriteString("file '" + strings.ReplaceAll(abs, "'", `'\''`) + "'\n")
+		}
+		err := os.WriteFile(listPath, []byte(list.String()), 0644)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = os.Remove(listPath) }()
+		args = []string{"-f", "concat", "-safe", "0", "-i", listPath}
+	}
+
+	args = append(args, "-c", "copy", outputPath)
+	return runFFmpeg(ctx, f.Path, args...)
+}
+
+// VerifyFile decodes every frame of filePath and fails if ffprobe reports
+// errors or the video or audio stream is missing.
+func (f *FFmpeg) VerifyFile(ctx context.Context, filePath string) error {
+	return f.verifyStreams(ctx, filePath, "video", "audio")
+}
+
+// VerifyAudioFile is VerifyFile for files of --audio-only, which have no
+// video stream.
+func (f *FFmpeg) VerifyAudioFile(ctx context.Context, filePath string) error {
+	return f.verifyStreams(ctx, filePath, "audio")
+}
+
+func (f *FFmpeg) verifyStreams(ctx context.Context, filePath string, codecTypes ...string) error {
+	cmd := exec.CommandContext(ctx, f.FFprobe, "-v", "error", "-count_frames",
+		"-show_entries", "stream=codec_type", "-of", "csv=p=0", filePath)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	buf, err := cmd.Output()
+	if err != nil {
+		return errors.Wrap(err, stderr.String())
+	}
+	if stderr.Len() > 0 {
+		return errors.Newf("decode errors: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	streams := strings.Fields(string(buf))
+	for _, codecType := range codecTypes {
+		if !slices.Contains(streams, codecType) {
+			return errors.Newf("%s stream is missing", codecType)
+		}
 	}
 	return nil
 }
@@ -0,0 +1,359 @@
+package bilibili
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/go-resty/resty/v2"
+	"github.com/ulikunitz/xz"
+	"github.com/urfave/cli/v3"
+	"go.uber.org/zap"
+
+	"github.com/fanyang89/media-collector/internal/downloader"
+)
+
+// Static builds of https://github.com/BtbN/FFmpeg-Builds. The latest release
+// is rebuilt in place every day, so the archive and checksums.sha256 listing
+// its SHA-256 are taken from an autobuild release, which never changes.
+const (
+	ffmpegReleasesAPI      = "https://api.github.com/repos/BtbN/FFmpeg-Builds/releases"
+	ffmpegReleaseURL       = "https://github.com/BtbN/FFmpeg-Builds/releases/download/"
+	ffmpegReleaseTagPrefix = "autobuild-"
+	ffmpegChecksumFile     = "checksums.sha256"
+)
+
+// ffmpegArchiveSuffixes end the archive names of master builds, like
+// ffmpeg-N-118000-g0123456789-linux64-gpl.tar.xz.
+var ffmpegArchiveSuffixes = map[string]string{
+	"linux/amd64":   "-linux64-gpl.tar.xz",
+	"linux/arm64":   "-linuxarm64-gpl.tar.xz",
+	"windows/amd64": "-win64-gpl.zip",
+	"windows/arm64": "-winarm64-gpl.zip",
+}
+
+type ffmpegRelease struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name string `json:"name"`
+	} `json:"assets"`
+}
+
+// archive returns the master build of release whose name ends with suffix.
+func (r *ffmpegRelease) archive(suffix string) (string, bool) {
+	for _, asset := range r.Assets {
+		if strings.HasPrefix(asset.Name, "ffmpeg-N-") && strings.HasSuffix(asset.Name, suffix) {
+			return asset.Name, true
+		}
+	}
+	return "", false
+}
+
+// getFFmpegRelease gets the release of tag, or the newest autobuild release
+// if tag is empty.
+func getFFmpegRelease(ctx context.Context, client *resty.Client, apiURL string, tag string) (*ffmpegRelease, error) {
+	if tag != "" {
+		var release ffmpegRelease
+		rsp, err := client.R().SetContext(ctx).SetResult(&release).Get(apiURL + "/tags/" + tag)
+		if err != nil {
+			return nil, errors.Wrap(err, "get release")
+		}
+		if rsp.IsError() {
+			return nil, errors.Newf("get release %s failed, status: %s", tag, rsp.Status())
+		}
+		return &release, nil
+	}
+
+	var releases []ffmpegRelease
+	rsp, err := client.R().SetContext(ctx).SetResult(&releases).Get(apiURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "list releases")
+	}
+	if rsp.IsError() {
+		return nil, errors.Newf("list releases failed, status: %s", rsp.Status())
+	}
+	for i := range releases {
+		if strings.HasPrefix(releases[i].TagName, ffmpegReleaseTagPrefix) {
+			return &releases[i], nil
+		}
+	}
+	return nil, errors.New("no autobuild release found")
+}
+
+// ffmpegCacheDir is where install-ffmpeg puts ffmpeg and ffprobe.
+func ffmpegCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "media-collector", "ffmpeg"), nil
+}
+
+// cachedFFmpeg returns ffmpeg installed by install-ffmpeg if there's one.
+func cachedFFmpeg() (string, bool) {
+	dir, err := ffmpegCacheDir()
+	if err != nil {
+		return "", false
+	}
+	p := filepath.Join(dir, "ffmpeg"+defaultExecutableFileExtension())
+	return p, fileExists(p)
+}
+
+// parseChecksums parses a sha256sum output into hashes by file name.
+func parseChecksums(r io.Reader) (map[string]string, error) {
+	checksums := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		checksums[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+	}
+	return checksums, scanner.Err()
+}
+
+func verifySHA256(filePath string, want string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	got := hex.EncodeToString(h.Sum(nil))
+	if got != want {
+		return errors.Newf("checksum mismatch of %s, expected %s, got %s", filepath.Base(filePath), want, got)
+	}
+	return nil
+}
+
+// isFFmpegBinary reports whether an archive entry is ffmpeg or ffprobe in
+// the bin directory.
+func isFFmpegBinary(name string) bool {
+	name = path.Clean(filepath.ToSlash(name))
+	if path.Base(path.Dir(name)) != "bin" {
+		return false
+	}
+	ext := defaultExecutableFileExtension()
+	base := path.Base(name)
+	return base == "ffmpeg"+ext || base == "ffprobe"+ext
+}
+
+func extractFile(r io.Reader, filePath string) error {
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	_, err = io.Copy(f, r)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// extractFFmpegTar extracts ffmpeg and ffprobe of a tar archive into dir.
+func extractFFmpegTar(r io.Reader, dir string) (int, error) {
+	count := 0
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		if header.Typeflag != tar.TypeReg || !isFFmpegBinary(header.Name) {
+			continue
+		}
+		err = extractFile(tr, filepath.Join(dir, path.Base(header.Name)))
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+}
+
+// extractFFmpegZip extracts ffmpeg and ffprobe of a zip archive into dir.
+func extractFFmpegZip(archivePath string, dir string) (int, error) {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = zr.Close() }()
+
+	count := 0
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !isFFmpegBinary(f.Name) {
+			continue
+		}
+		err = func() error {
+			r, err := f.Open()
+			if err != nil {
+				return err
+			}
+			defer func() { _ = r.Close() }()
+			return extractFile(r, filepath.Join(dir, path.Base(f.Name)))
+		}()
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func extractFFmpeg(archivePath string, dir string) error {
+	var count int
+	var err error
+	if strings.HasSuffix(archivePath, ".zip") {
+		count, err = extractFFmpegZip(archivePath, dir)
+	} else {
+		var f *os.File
+		f, err = os.Open(archivePath)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		var xr *xz.Reader
+		xr, err = xz.NewReader(bufio.NewReader(f))
+		if err != nil {
+			return err
+		}
+		count, err = extractFFmpegTar(xr, dir)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "extract %s", filepath.Base(archivePath))
+	}
+	if count == 0 {
+		return errors.Newf("ffmpeg not found in %s", filepath.Base(archivePath))
+	}
+	return nil
+}
+
+// InstallFFmpeg downloads a static ffmpeg build of the release of tag, or
+// the newest one if tag is empty, for this platform into the cache directory
+// and returns the path of ffmpeg.
+func InstallFFmpeg(ctx context.Context, tag string) (string, error) {
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	suffix, ok := ffmpegArchiveSuffixes[platform]
+	if !ok {
+		return "", errors.Newf("no static ffmpeg build for %s, please install ffmpeg with the package manager", platform)
+	}
+	dir, err := ffmpegCacheDir()
+	if err != nil {
+		return "", err
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	client := resty.New()
+	release, err := getFFmpegRelease(ctx, client, ffmpegReleasesAPI, tag)
+	if err != nil {
+		return "", err
+	}
+	archive, ok := release.archive(suffix)
+	if !ok {
+		return "", errors.Newf("no static ffmpeg build for %s in release %s", platform, release.TagName)
+	}
+	zap.L().Info("Installing ffmpeg", zap.String("release", release.TagName), zap.String("archive", archive))
+
+	releaseURL := ffmpegReleaseURL + release.TagName + "/"
+	rsp, err := client.R().SetContext(ctx).Get(releaseURL + ffmpegChecksumFile)
+	if err != nil {
+		return "", errors.Wrap(err, "get checksums")
+	}
+	if rsp.IsError() {
+		return "", errors.Newf("get checksums failed, status: %s", rsp.Status())
+	}
+	checksums, err := parseChecksums(strings.NewReader(rsp.String()))
+	if err != nil {
+		return "", err
+	}
+	checksum, ok := checksums[archive]
+	if !ok {
+		return "", errors.Newf("checksum of %s not found", archive)
+	}
+
+	archivePath := filepath.Join(dir, archive)
+	defer func() { _ = os.Remove(archivePath) }()
+	err = downloader.Download(ctx, archivePath, []string{releaseURL + archive}, downloader.Options{
+		Client:      client,
+		ReadTimeout: defaultReadTimeout,
+		Progress: func(fileName string, contentLength int64) downloader.ProgressBar {
+			_, _ = fmt.Fprintf(barWriter, "Downloading %s\n", fileName)
+			return NewProgressBar(contentLength, "")
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	err = verifySHA256(archivePath, checksum)
+	if err != nil {
+		return "", err
+	}
+
+	err = extractFFmpeg(archivePath, dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ffmpeg"+defaultExecutableFileExtension()), nil
+}
+
+var InstallFFmpegCmd = &cli.Command{
+	Name:  "install-ffmpeg",
+	Usage: "Download a static ffmpeg build, used when ffmpeg is neither configured nor on PATH",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "config",
+			Aliases: []string{"c"},
+			Value:   "config.yml",
+		},
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "Download even if ffmpeg is already present",
+		},
+		&cli.StringFlag{
+			Name:  "release",
+			Usage: "Tag of the BtbN/FFmpeg-Builds release to install, like autobuild-2025-01-31-12-55, the newest by default",
+		},
+	},
+	Action: func(ctx context.Context, command *cli.Command) error {
+		if !command.Bool("force") {
+			config, err := LoadConfig(configPathFromCliCommand(command))
+			if err != nil {
+				return err
+			}
+			if p, err := resolveFFmpeg(config.FFmpeg); err == nil {
+				fmt.Printf("ffmpeg is already present at %s\n", p)
+				return nil
+			}
+		}
+
+		p, err := InstallFFmpeg(ctx, command.String("release"))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("ffmpeg installed to %s\n", p)
+		return nil
+	},
+}
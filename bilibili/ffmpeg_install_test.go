@@ -0,0 +1,136 @@
+package bilibili
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+)
+
+func TestParseChecksums(t *testing.T) {
+	checksums, err := parseChecksums(strings.NewReader("ABC  a.tar.xz\ndef *b.zip\n\ninvalid\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checksums["a.tar.xz"] != "abc" || checksums["b.zip"] != "def" || len(checksums) != 2 {
+		t.Errorf("unexpected checksums: %v", checksums)
+	}
+}
+
+func TestVerifySHA256(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "archive")
+	err := os.WriteFile(filePath, []byte("content"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("content"))
+
+	err = verifySHA256(filePath, hex.EncodeToString(sum[:]))
+	if err != nil {
+		t.Error(err)
+	}
+	err = verifySHA256(filePath, strings.Repeat("0", 64))
+	if err == nil {
+		t.Error("expected checksum mismatch")
+	}
+}
+
+func TestExtractFFmpegTar(t *testing.T) {
+	ext := defaultExecutableFileExtension()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range []string{
+		"ffmpeg-build/bin/ffmpeg" + ext,
+		"ffmpeg-build/bin/ffprobe" + ext,
+		"ffmpeg-build/bin/ffplay" + ext,
+		"ffmpeg-build/doc/ffmpeg" + ext,
+	} {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(name)), Typeflag: tar.TypeReg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = tw.Write([]byte(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := tw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	count, err := extractFFmpegTar(&buf, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("count = %d, want 2", count)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "ffmpeg"+ext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "ffmpeg-build/bin/ffmpeg"+ext {
+		t.Errorf("unexpected ffmpeg content: %q", content)
+	}
+	if fileExists(filepath.Join(dir, "ffplay"+ext)) {
+		t.Error("ffplay should not be extracted")
+	}
+}
+
+func TestGetFFmpegRelease(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/releases":
+			_, _ = w.Write([]byte(`[
+				{"tag_name":"latest","assets":[{"name":"ffmpeg-master-latest-linux64-gpl.tar.xz"}]},
+				{"tag_name":"autobuild-2025-01-31-12-55","assets":[
+					{"name":"ffmpeg-n7.1-linux64-gpl-7.1.tar.xz"},
+					{"name":"ffmpeg-N-118385-g0123456789-linux64-gpl.tar.xz"}
+				]}
+			]`))
+		case "/releases/tags/autobuild-2025-01-01-12-55":
+			_, _ = w.Write([]byte(`{"tag_name":"autobuild-2025-01-01-12-55","assets":[]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	client := resty.New()
+	release, err := getFFmpegRelease(ctx, client, server.URL+"/releases", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if release.TagName != "autobuild-2025-01-31-12-55" {
+		t.Errorf("TagName = %s, want the newest autobuild release", release.TagName)
+	}
+	archive, ok := release.archive("-linux64-gpl.tar.xz")
+	if !ok || archive != "ffmpeg-N-118385-g0123456789-linux64-gpl.tar.xz" {
+		t.Errorf("archive() = %s, %v, want the master build", archive, ok)
+	}
+	if _, ok := release.archive("-win64-gpl.zip"); ok {
+		t.Error("archive() found a build the release doesn't have")
+	}
+
+	release, err = getFFmpegRelease(ctx, client, server.URL+"/releases", "autobuild-2025-01-01-12-55")
+	if err != nil || release.TagName != "autobuild-2025-01-01-12-55" {
+		t.Errorf("getFFmpegRelease() of tag = %+v, %v", release, err)
+	}
+	_, err = getFFmpegRelease(ctx, client, server.URL+"/releases", "missing")
+	if err == nil {
+		t.Error("getFFmpegRelease() of a missing tag succeeded")
+	}
+}
@@ -0,0 +1,97 @@
+package bilibili
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+)
+
+func TestFFmpegError(t *testing.T) {
+	banner := strings.Repeat("  configuration: --enable-gpl\n", 20)
+	for _, test := range []struct {
+		name   string
+		output string
+		mark   error
+	}{
+		{name: "missing", output: banner + "video.m4s: No such file or directory\n", mark: ErrFFmpegInputMissing},
+		{name: "moov", output: banner + "[mov,mp4,m4a,3gp,3g2,mj2 @ 0x1] moov atom not found\nvideo.m4s: Invalid data found when processing input\n", mark: ErrFFmpegCorruptInput},
+		{name: "other", output: banner + "Could not write header for output file #0\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := ffmpegError(errors.New("exit status 1"), test.output)
+			if test.mark != nil && !errors.Is(err, test.mark) {
+				t.Errorf("error is not marked %v: %v", test.mark, err)
+			}
+			lines := strings.Split(strings.TrimSpace(test.output), "\n")
+			if !strings.Contains(err.Error(), lines[len(lines)-1]) || strings.Count(err.Error(), "\n") >= ffmpegErrorLines {
+				t.Errorf("error should keep the last %d lines: %v", ffmpegErrorLines, err)
+			}
+		})
+	}
+}
+
+func TestExtractAudio(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+
+	dir := t.TempDir()
+	// the fake ffmpeg writes its arguments to the output file
+	f := FFmpeg{Path: filepath.Join(dir, "ffmpeg")}
+	err := os.WriteFile(f.Path, []byte("#!/bin/sh\nfor last; do :; done\necho \"$@\" > \"$last\"\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		format string
+		want   string
+	}{
+		{format: audioFormatNative, want: "-vn -c:a copy"},
+		{format: audioFormatM4a, want: "-vn -c:a copy"},
+		{format: audioFormatMp3, want: "-vn -c:a libmp3lame -b:a 192k"},
+		{format: audioFormatFlac, want: "-vn -c:a flac"},
+	} {
+		outputPath := filepath.Join(dir, "output")
+		err = f.ExtractAudio(context.Background(), "input.m4s", outputPath, test.format, defaultAudioBitrate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		args, err := os.ReadFile(outputPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(args), test.want) {
+			t.Errorf("ExtractAudio(%q) args = %q, want %q", test.format, args, test.want)
+		}
+	}
+}
+
+func TestResolveFFprobe(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PATH", dir)
+	ffprobePath := filepath.Join(dir, "ffprobe"+defaultExecutableFileExtension())
+
+	_, err := resolveFFprobe(filepath.Join(dir, "bin", "ffprobe"))
+	if err == nil {
+		t.Error("resolveFFprobe() succeeded without ffprobe")
+	}
+
+	err = os.WriteFile(ffprobePath, []byte{}, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := resolveFFprobe(ffprobePath)
+	if err != nil || p != ffprobePath {
+		t.Errorf("resolveFFprobe() of an existing ffprobe = %s, %v", p, err)
+	}
+	p, err = resolveFFprobe(filepath.Join(dir, "bin", "ffprobe"))
+	if err != nil || p != ffprobePath {
+		t.Errorf("resolveFFprobe() = %s, %v, want ffprobe on PATH", p, err)
+	}
+}
@@ -0,0 +1,224 @@
+package bilibili
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cockroachdb/errors"
+	"github.com/flytam/filenamify"
+)
+
+const defaultOutputTemplate = "{{.Author}} - {{.Title}}{{if .Suffix}}_{{.Suffix}}{{end}}.{{.Ext}}"
+
+// defaultMaxFileNameLength is the limit in bytes of a single path component
+// on most filesystems.
+const defaultMaxFileNameLength = 255
+
+// fileNameReserve is kept free in rendered names for the .part suffix of
+// files being downloaded, and for the sidecar extensions which are longer
+// than the one they replace.
+const fileNameReserve = max(len(partFileSuffix), len(descriptionExt)-len(".mp4"))
+
+type fileNameData struct {
+	Author string
+	Title  string
+	Bvid   string
+	Cid    int
+	Suffix string
+	Ext    string
+}
+
+type fileNamer struct {
+	tmpl      *template.Template
+	maxLength int
+}
+
+func newFileNamer(outputTemplate string, maxLength int) (*fileNamer, error) {
+	if outputTemplate == "" {
+		outputTemplate = defaultOutputTemplate
+	}
+	tmpl, err := template.New("output").Option("missingkey=error").Parse(outputTemplate)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse output template")
+	}
+	if maxLength <= 0 {
+		maxLength = defaultMaxFileNameLength
+	}
+	if maxLength <= fileNameReserve {
+		return nil, errors.Newf("max file name length %d is too short", maxLength)
+	}
+	return &fileNamer{tmpl: tmpl, maxLength: maxLength - fileNameReserve}, nil
+}
+
+// pathSeparatorReplacer keeps separators in field values from creating
+// directories, only separators in the template itself should do that.
+var pathSeparatorReplacer = strings.NewReplacer("/", "_", "\\", "_")
+
+// newFileName renders the output template, truncating the title so that no
+// path component exceeds the length limit. The bvid is appended to the title
+// if option.Disambiguate is set.
+func (n *fileNamer) newFileName(option DownloadOption, suffix string, format string) (string, error) {
+	format = normalizeFormat(format)
+	if option.OutputName != "" {
+		return n.outputName(option, suffix, format)
+	}
+
+	// the bvid is appended after truncation so that it's never cut
+	var bvidTag string
+	if option.Disambiguate {
+		bvidTag = " [" + option.Bvid + "]"
+	}
+	title := pathSeparatorReplacer.Replace(option.Title)
+	for {
+		segments, err := n.render(fileNameData{
+			Author: pathSeparatorReplacer.Replace(option.OwnerName),
+			Title:  title + bvidTag,
+			Bvid:   option.Bvid,
+			Cid:    option.Cid,
+			Suffix: suffix,
+			Ext:    format,
+		})
+		if err != nil {
+			return "", errors.Wrapf(err, "bvid: %s", option.Bvid)
+		}
+
+		overflow := 0
+		for _, segment := range segments {
+			overflow = max(overflow, len(segment)-n.maxLength)
+		}
+		if overflow <= 0 {
+			return filepath.Join(segments...), nil
+		}
+		if title == "" {
+			return "", errors.Newf("file name too long, bvid: %s", option.Bvid)
+		}
+		title = truncateString(title, len(title)-overflow)
+	}
+}
+
+// normalizeFormat turns mime types and durl formats like video/mp4 or flv720
+// into extensions.
+func normalizeFormat(format string) string {
+	if strings.Contains(format, "mp4") {
+		return "mp4"
+	} else if strings.Contains(format, "flv") {
+		return "flv"
+	}
+	return format
+}
+
+// tempFileName names a temporary file of option by bvid and cid like
+// BV1xx411c7mD_100.video.m4s, so that streams and segments of a video never
+// share a name whatever the output template is.
+func tempFileName(option DownloadOption, kind string, ext string) string {
+	return fmt.Sprintf("%s_%d.%s.%s", option.Bvid, option.Cid, kind, normalizeFormat(ext))
+}
+
+// outputName names the file by the output name of option instead of the
+// template.
+func (n *fileNamer) outputName(option DownloadOption, suffix string, format string) (string, error) {
+	name := strings.TrimSuffix(option.OutputName, "."+format)
+	if suffix != "" {
+		name += "_" + suffix
+	}
+	name, err := filenamify.FilenamifyV2(name+"."+format, func(options *filenamify.Options) {
+		options.MaxLength = math.MaxInt
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "sanitize file name")
+	}
+	if len(name) > n.maxLength {
+		return "", errors.Newf("output name too long: %s", option.OutputName)
+	}
+	return name, nil
+}
+
+// validateOutputName rejects output names which would escape the output
+// directory, subdirectories are created by --group-by.
+func validateOutputName(name string) error {
+	if strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return errors.Newf("invalid output name: %s, it can't contain path separators, use --group-by for subdirectories", name)
+	}
+	return nil
+}
+
+func (n *fileNamer) render(data fileNameData) ([]string, error) {
+	var b strings.Builder
+	err := n.tmpl.Execute(&b, data)
+	if err != nil {
+		return nil, errors.Wrap(err, "render file name")
+	}
+
+	segments := strings.Split(filepath.ToSlash(b.String()), "/")
+	for i, segment := range segments {
+		segments[i], err = filenamify.FilenamifyV2(segment, func(options *filenamify.Options) {
+			options.MaxLength = math.MaxInt
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "sanitize file name")
+		}
+	}
+	return segments, nil
+}
+
+// truncateString cuts s to at most n bytes without splitting a rune.
+func truncateString(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+const (
+	groupByNone    = "none"
+	groupByAuthor  = "author"
+	groupByKeyword = "keyword"
+	groupByDate    = "date"
+)
+
+func validateGroupBy(groupBy string) error {
+	switch groupBy {
+	case "", groupByNone, groupByAuthor, groupByKeyword, groupByDate:
+		return nil
+	}
+	return errors.Newf("invalid group by: %s, should be author, keyword, date or none", groupBy)
+}
+
+// groupDir returns the subdirectory of the output directory for merged files
+// of option, videos without the grouping field go to "unknown".
+func groupDir(groupBy string, option DownloadOption, now time.Time) (string, error) {
+	var name string
+	switch groupBy {
+	case "", groupByNone:
+		return "", nil
+	case groupByAuthor:
+		name = option.OwnerName
+	case groupByKeyword:
+		name = option.SearchKeyword
+	case groupByDate:
+		name = now.Format(time.DateOnly)
+	default:
+		return "", validateGroupBy(groupBy)
+	}
+	if name == "" {
+		name = "unknown"
+	}
+	name, err := filenamify.FilenamifyV2(pathSeparatorReplacer.Replace(name), func(options *filenamify.Options) {
+		options.MaxLength = defaultMaxFileNameLength
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "sanitize group directory")
+	}
+	return name, nil
+}
@@ -0,0 +1,187 @@
+package bilibili
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestNewFileName(t *testing.T) {
+	option := DownloadOption{
+		Bvid:      "BV1y7411Q7Eq",
+		Cid:       1,
+		OwnerName: "author",
+		Title:     "a/b",
+	}
+	for _, test := range []struct {
+		template string
+		suffix   string
+		format   string
+		want     string
+	}{
+		{template: "", suffix: "", format: "mp4", want: "author - a_b.mp4"},
+		{template: "", suffix: "video", format: "video/mp4", want: "author - a_b_video.mp4"},
+		{template: "{{.Bvid}}/{{.Title}}.{{.Ext}}", suffix: "", format: "mp4", want: filepath.Join("BV1y7411Q7Eq", "a_b.mp4")},
+	} {
+		n, err := newFileNamer(test.template, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := n.newFileName(option, test.suffix, test.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("newFileName(%q) = %q, want %q", test.template, got, test.want)
+		}
+	}
+}
+
+func TestNewFileNameOutputName(t *testing.T) {
+	n, err := newFileNamer("{{.Bvid}}/{{.Title}}.{{.Ext}}", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	option := DownloadOption{Bvid: "BV1y7411Q7Eq", Title: "t", OutputName: "clip.mp4"}
+	for _, test := range []struct {
+		suffix string
+		format string
+		want   string
+	}{
+		{suffix: "", format: "mp4", want: "clip.mp4"},
+		{suffix: "", format: "mkv", want: "clip.mp4.mkv"},
+		{suffix: "audio", format: "audio/mp4", want: "clip_audio.mp4"},
+	} {
+		got, err := n.newFileName(option, test.suffix, test.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("newFileName(%q, %q) = %q, want %q", test.suffix, test.format, got, test.want)
+		}
+	}
+
+	for _, name := range []string{"a/b", `a\b`, ".."} {
+		if validateOutputName(name) == nil {
+			t.Errorf("validateOutputName(%q) should fail", name)
+		}
+	}
+	if err := validateOutputName("clip"); err != nil {
+		t.Errorf("validateOutputName(clip): %v", err)
+	}
+}
+
+func TestNewFileNameDisambiguate(t *testing.T) {
+	n, err := newFileNamer("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := DownloadOption{Bvid: "BV1y7411Q7Eq", OwnerName: "author", Title: "无标题"}
+	b := DownloadOption{Bvid: "BV17x411w7KC", OwnerName: "author", Title: "无标题"}
+	nameA, _ := n.newFileName(a, "", "mp4")
+	nameB, _ := n.newFileName(b, "", "mp4")
+	if nameA != nameB {
+		t.Fatalf("names %q and %q should collide", nameA, nameB)
+	}
+
+	b.Disambiguate = true
+	nameB, err = n.newFileName(b, "", "mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "author - 无标题 [BV17x411w7KC].mp4"; nameB != want {
+		t.Errorf("newFileName() = %q, want %q", nameB, want)
+	}
+
+	n.maxLength = 40
+	b.Title = strings.Repeat("a", 100)
+	nameB, err = n.newFileName(b, "", "mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nameB) > n.maxLength || !strings.HasSuffix(nameB, " [BV17x411w7KC].mp4") {
+		t.Errorf("truncated name %q lost the bvid", nameB)
+	}
+}
+
+func TestNewFileNamePathologicalTitles(t *testing.T) {
+	n, err := newFileNamer("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, title := range []string{
+		"",
+		".",
+		"..",
+		"😀😀😀",
+		"<>:\"/\\|?*",
+		strings.Repeat("超长标题", 100),
+	} {
+		name, err := n.newFileName(DownloadOption{OwnerName: "author", Title: title}, "video", "mp4")
+		if err != nil {
+			t.Errorf("newFileName(%q) failed: %v", title, err)
+			continue
+		}
+		if name == "" {
+			t.Errorf("newFileName(%q) is empty", title)
+		}
+	}
+}
+
+func TestNewFileNameTruncateTitle(t *testing.T) {
+	const maxLength = 200
+	n, err := newFileNamer("", maxLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	title := strings.Repeat("长", 300)
+	name, err := n.newFileName(DownloadOption{OwnerName: "author", Title: title}, "video", "mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(name+partFileSuffix) > maxLength {
+		t.Errorf("len(name) = %d, want <= %d with room for %s", len(name), maxLength, partFileSuffix)
+	}
+	if sidecar := sidecarPath(name, ".description"); len(sidecar) > maxLength {
+		t.Errorf("len(%s) = %d, want <= %d", sidecar, len(sidecar), maxLength)
+	}
+	if !utf8.ValidString(name) {
+		t.Errorf("name is not valid UTF-8: %q", name)
+	}
+	if !strings.HasPrefix(name, "author - 长") || !strings.HasSuffix(name, "长_video.mp4") {
+		t.Errorf("unexpected name: %q", name)
+	}
+}
+
+func TestGroupDir(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	option := DownloadOption{OwnerName: "a/b", SearchKeyword: "keyword"}
+	for _, test := range []struct {
+		groupBy string
+		option  DownloadOption
+		want    string
+	}{
+		{groupBy: groupByNone, option: option, want: ""},
+		{groupBy: groupByAuthor, option: option, want: "a_b"},
+		{groupBy: groupByKeyword, option: option, want: "keyword"},
+		{groupBy: groupByKeyword, option: DownloadOption{}, want: "unknown"},
+		{groupBy: groupByDate, option: option, want: "2024-05-01"},
+	} {
+		got, err := groupDir(test.groupBy, test.option, now)
+		if err != nil {
+			t.Errorf("groupDir(%q): %v", test.groupBy, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("groupDir(%q) = %q, want %q", test.groupBy, got, test.want)
+		}
+	}
+
+	_, err := groupDir("title", option, now)
+	if err == nil {
+		t.Error("expected error for invalid group by")
+	}
+}
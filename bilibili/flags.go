@@ -0,0 +1,177 @@
+package bilibili
+
+import (
+	"github.com/urfave/cli/v3"
+)
+
+// DownloadFlags are the flags of downloading videos, shared by the download
+// commands and get. They are read by downloaderFromCliCommand.
+func DownloadFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "config",
+			Aliases: []string{"c"},
+			Value:   "config.yml",
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "Output directory, overrides output in config",
+		},
+		&cli.StringFlag{
+			Name:  "ffmpeg",
+			Usage: "Path of ffmpeg, overrides ffmpeg in config",
+		},
+		&cli.StringFlag{
+			Name:  "profile",
+			Usage: "Account profile in config",
+			Value: defaultProfile,
+		},
+		&cli.BoolFlag{
+			Name:  "auto-login",
+			Usage: "Login again with QR code when cookies expired",
+		},
+		&cli.BoolFlag{
+			Name:  "verify",
+			Usage: "Verify the merged file with ffprobe",
+		},
+		&cli.DurationFlag{
+			Name:  "read-timeout",
+			Usage: "Timeout of a single read of a download stream, 0 means no timeout",
+			Value: defaultReadTimeout,
+		},
+		&cli.DurationFlag{
+			Name:  "download-timeout",
+			Usage: "Timeout of downloading a single file, 0 means no timeout",
+			Value: defaultDownloadTimeout,
+		},
+		&cli.StringFlag{
+			Name:  "container",
+			Usage: "Container of the merged file, mp4 or mkv",
+			Value: containerMp4,
+		},
+		&cli.StringFlag{
+			Name:  "codec",
+			Usage: "Preferred video codec, avc, hevc, av1 or any",
+			Value: codecAny,
+		},
+		&cli.Int64Flag{
+			Name:  "min-free-space",
+			Usage: "Skip downloads which would leave less than this many bytes free, 0 means no check",
+		},
+		&cli.BoolFlag{
+			Name:  "overwrite",
+			Usage: "Download again even if history has it or the file exists, takes precedence over --skip-existing",
+		},
+		&cli.BoolFlag{
+			Name:  "skip-existing",
+			Usage: "Skip videos whose output file exists, --skip-existing=false downloads them again unless history has them",
+			Value: true,
+		},
+		&cli.StringFlag{
+			Name:  "group-by",
+			Usage: "Put merged files into subdirectories by author, keyword, date or none",
+			Value: groupByNone,
+		},
+		&cli.StringFlag{
+			Name:  "audio-quality",
+			Usage: "Audio track, normal, flac (Hi-Res), dolby, or best of them, falls back to normal if not available",
+			Value: audioQualityNormal,
+		},
+		&cli.BoolFlag{
+			Name:  "keep-temp",
+			Usage: "Keep the streams of a failed merge to merge them again next run, --keep-temp=false removes them",
+			Value: true,
+		},
+		&cli.BoolFlag{
+			Name:  "compat",
+			Usage: "Transcode AV1 videos to H.264 for players without AV1 support, CPU intensive and much slower",
+		},
+		&cli.IntFlag{
+			Name:  "compat-crf",
+			Usage: "CRF of H.264 transcoded by --compat, lower is better and larger, 0-51",
+			Value: defaultCompatCRF,
+		},
+		&cli.BoolFlag{
+			Name:  "write-info-json",
+			Usage: "Write the metadata of the video to <name>.info.json next to it, in the format of yt-dlp",
+		},
+		&cli.BoolFlag{
+			Name:  "write-description",
+			Usage: "Write the description of the video to <name>.description next to it",
+		},
+		&cli.StringSliceFlag{
+			Name:  "proxy-list",
+			Usage: "Proxies to send requests through in turn, like http://host:port or socks5://host:port, overrides proxies in config",
+		},
+		&cli.IntFlag{
+			Name:  "retries",
+			Usage: "Retries of a video whose download failed with a transient error, overrides retries in config",
+		},
+		&cli.BoolFlag{
+			Name:  "audio-only",
+			Usage: "Download only the audio track, to <name>.m4a unless --extract-audio-format is set",
+		},
+		&cli.StringFlag{
+			Name:  "extract-audio-format",
+			Usage: "Format of --audio-only, mp3, m4a or flac, transcoded with ffmpeg if the track isn't in it, keeps the track as is if not set",
+		},
+		&cli.StringFlag{
+			Name:  "audio-bitrate",
+			Usage: "Bitrate of mp3 extracted by --extract-audio-format",
+			Value: defaultAudioBitrate,
+		},
+		&cli.BoolFlag{
+			Name:  "all-branches",
+			Usage: "Download every branch of interactive videos, only the first one is downloaded otherwise",
+		},
+	}
+}
+
+// batchFlags are the flags of the download commands of lists of videos, see
+// runBatch.
+func batchFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Print videos to download without downloading",
+		},
+		&cli.Int64Flag{
+			Name:  "max-total-size",
+			Usage: "Stop once merged files of this run reach this size in bytes, 0 means no limit",
+		},
+		&cli.DurationFlag{
+			Name:  "deadline",
+			Usage: "Stop the batch after this duration, videos not started are skipped, 0 means no deadline",
+		},
+		&cli.DurationFlag{
+			Name:  "item-timeout",
+			Usage: "Timeout of downloading a single video in the batch, 0 means no timeout",
+		},
+		&cli.StringFlag{
+			Name:  "report",
+			Usage: "Write a JSON summary of the batch to this file",
+		},
+	}
+}
+
+// notifyFlags are the flags of notifying the summary of a batch, see
+// notifyBatch.
+func notifyFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "notify-webhook",
+			Usage: "POST the JSON summary of the batch to this URL when it completes",
+		},
+		&cli.StringFlag{
+			Name:    "notify-telegram-token",
+			Usage:   "Token of the Telegram bot sending the summary of the batch",
+			Sources: cli.EnvVars("TELEGRAM_BOT_TOKEN"),
+		},
+		&cli.StringFlag{
+			Name:    "notify-telegram-chat",
+			Usage:   "Telegram chat ID to send the summary of the batch to",
+			Sources: cli.EnvVars("TELEGRAM_CHAT_ID"),
+		},
+	}
+}
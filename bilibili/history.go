@@ -1,9 +1,22 @@
 package bilibili
 
 import (
+	"encoding/csv"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/cockroachdb/errors"
 	"github.com/xuri/excelize/v2"
 	"go.uber.org/zap"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
@@ -12,28 +25,127 @@ import (
 
 type History struct {
 	db *gorm.DB
+	mu sync.Mutex
+
+	// pending are the entries saved but not written yet while saves are
+	// buffered, they are written once bufferSize of them are pending
+	pending    []*HistoryEntry
+	bufferSize int
 }
 
+// Kinds of downloads in history, a video downloaded with --audio-only is
+// still to be downloaded in full and the other way round.
+const (
+	historyKindVideo = "video"
+	historyKindAudio = "audio"
+)
+
+// HistoryEntry is a downloaded video part, Cid is 0 for entries recorded
+// before parts were tracked.
 type HistoryEntry struct {
-	Bvid     string `json:"bvid"`
-	Author   string `json:"author"`
-	Title    string `json:"title"`
-	Keyword  string `json:"keyword"`
+	Bvid    string `json:"bvid" gorm:"primaryKey"`
+	Cid     int    `json:"cid" gorm:"primaryKey;autoIncrement:false"`
+	Kind    string `json:"kind" gorm:"primaryKey"`
+	Author  string `json:"author"`
+	Title   string `json:"title"`
+	Keyword string `json:"keyword"`
+	Folder  string `json:"folder"`
+	// Tags is a JSON array, use GetTags and SetTags. Old versions joined
+	// tags with semicolons.
 	Tags     string `json:"tags"`
-	FileName string `json:"file_name"`
+	FileName string `json:"file_name" gorm:"index"`
+
+	DownloadedAt time.Time `json:"downloaded_at"`
+	FileSize     int64     `json:"file_size"`
+}
+
+func (e *HistoryEntry) GetTags() []string {
+	if e.Tags == "" {
+		return nil
+	}
+	var tags []string
+	if strings.HasPrefix(e.Tags, "[") && json.Unmarshal([]byte(e.Tags), &tags) == nil {
+		return tags
+	}
+	for _, tag := range strings.Split(e.Tags, ";") {
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func (e *HistoryEntry) SetTags(tags []string) {
+	if len(tags) == 0 {
+		e.Tags = ""
+		return
+	}
+	buf, _ := json.Marshal(tags)
+	e.Tags = string(buf)
+}
+
+type historyEntryAlias HistoryEntry
+
+// historyEntryJSON exports tags as an array instead of the column.
+type historyEntryJSON struct {
+	*historyEntryAlias
+	Tags []string `json:"tags"`
 }
 
-func NewHistory(dsn string) (*History, error) {
+func (e *HistoryEntry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(historyEntryJSON{historyEntryAlias: (*historyEntryAlias)(e), Tags: e.GetTags()})
+}
+
+func (e *HistoryEntry) UnmarshalJSON(buf []byte) error {
+	v := historyEntryJSON{historyEntryAlias: (*historyEntryAlias)(e)}
+	err := json.Unmarshal(buf, &v)
+	if err != nil {
+		return err
+	}
+	e.SetTags(v.Tags)
+	return nil
+}
+
+const (
+	historyDriverSqlite   = "sqlite"
+	historyDriverPostgres = "postgres"
+	historyDriverMysql    = "mysql"
+)
+
+func historyDialector(driver string, dsn string) (gorm.Dialector, error) {
+	switch driver {
+	case "", historyDriverSqlite:
+		return sqlite.Open(dsn), nil
+	case historyDriverPostgres:
+		return postgres.Open(dsn), nil
+	case historyDriverMysql:
+		return mysql.Open(dsn), nil
+	}
+	return nil, errors.Newf("invalid history driver: %s", driver)
+}
+
+// NewHistory opens the history database, driver is one of sqlite, postgres
+// and mysql, an empty driver means sqlite.
+func NewHistory(driver string, dsn string) (*History, error) {
+	dialector, err := historyDialector(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
 	log := zapgorm2.New(zap.L())
 	log.IgnoreRecordNotFoundError = true
-	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+	db, err := gorm.Open(dialector, &gorm.Config{
 		Logger: log,
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	err = db.AutoMigrate(&HistoryEntry{})
+	err = migrateHistory(db)
+	if err != nil {
+		return nil, err
+	}
+	err = db.AutoMigrate(&SearchState{}, &RestrictedVideo{})
 	if err != nil {
 		return nil, err
 	}
@@ -41,13 +153,174 @@ func NewHistory(dsn string) (*History, error) {
 	return &History{db: db}, nil
 }
 
+const legacyHistoryTable = "history_entries_legacy"
+
+// migrateHistory creates the history table, tables of old versions keyed by
+// nothing or by (bvid, cid) are moved to one keyed by (bvid, cid, kind) with
+// duplicates dropped.
+func migrateHistory(db *gorm.DB) error {
+	// a failure midway rolls back to the legacy table, so that the next run
+	// migrates it again instead of finding half of it
+	return db.Transaction(func(tx *gorm.DB) error {
+		m := tx.Migrator()
+		legacy := m.HasTable(&HistoryEntry{}) &&
+			(!m.HasColumn(&HistoryEntry{}, "Cid") || !m.HasColumn(&HistoryEntry{}, "Kind"))
+		if legacy {
+			zap.L().Info("Migrating history table")
+			err := m.RenameTable(&HistoryEntry{}, legacyHistoryTable)
+			if err != nil {
+				return errors.Wrap(err, "rename legacy history table")
+			}
+		}
+
+		err := tx.AutoMigrate(&HistoryEntry{})
+		if err != nil {
+			return err
+		}
+		if !legacy {
+			return nil
+		}
+
+		var entries []HistoryEntry
+		err = tx.Table(legacyHistoryTable).Find(&entries).Error
+		if err != nil {
+			return errors.Wrap(err, "read legacy history")
+		}
+		for i := range entries {
+			entries[i].Kind = historyKindVideo
+		}
+		if len(entries) > 0 {
+			err = tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(entries, 100).Error
+			if err != nil {
+				return errors.Wrap(err, "copy legacy history")
+			}
+		}
+		return m.DropTable(legacyHistoryTable)
+	})
+}
+
 func (h *History) Save(entry *HistoryEntry) error {
+	if entry.DownloadedAt.IsZero() {
+		entry.DownloadedAt = time.Now()
+	}
+	if entry.Kind == "" {
+		entry.Kind = historyKindVideo
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.bufferSize > 0 {
+		h.pending = append(h.pending, entry)
+		if len(h.pending) < h.bufferSize {
+			return nil
+		}
+		return h.flush()
+	}
 	return h.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(entry).Error
 }
 
-func (h *History) IsDownloaded(bvid string) (ok bool, err error) {
+// SaveBatch saves entries in a single transaction.
+func (h *History) SaveBatch(entries []*HistoryEntry) error {
+	for _, entry := range entries {
+		if entry.DownloadedAt.IsZero() {
+			entry.DownloadedAt = time.Now()
+		}
+		if entry.Kind == "" {
+			entry.Kind = historyKindVideo
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.saveBatch(entries)
+}
+
+func (h *History) saveBatch(entries []*HistoryEntry) error {
+	// an upsert can't touch a row twice, keep the last entry of a part
+	type part struct {
+		bvid string
+		cid  int
+		kind string
+	}
+	latest := make(map[part]int, len(entries))
+	for i, entry := range entries {
+		latest[part{entry.Bvid, entry.Cid, entry.Kind}] = i
+	}
+	unique := make([]*HistoryEntry, 0, len(latest))
+	for i, entry := range entries {
+		if latest[part{entry.Bvid, entry.Cid, entry.Kind}] == i {
+			unique = append(unique, entry)
+		}
+	}
+	if len(unique) == 0 {
+		return nil
+	}
+
+	return h.db.Transaction(func(tx *gorm.DB) error {
+		return tx.Clauses(clause.OnConflict{UpdateAll: true}).CreateInBatches(unique, 100).Error
+	})
+}
+
+// BufferSaves makes Save keep entries in memory and write size of them at
+// once, 0 writes every entry immediately again. Call Flush to write the
+// pending entries before that.
+func (h *History) BufferSaves(size int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.bufferSize = size
+}
+
+// Flush writes the pending entries, they are kept to be written next time if
+// it fails.
+func (h *History) Flush() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.flush()
+}
+
+func (h *History) flush() error {
+	if len(h.pending) == 0 {
+		return nil
+	}
+	err := h.saveBatch(h.pending)
+	if err != nil {
+		return errors.Wrapf(err, "save %d history entries", len(h.pending))
+	}
+	h.pending = nil
+	return nil
+}
+
+// isPending checks IsDownloadedKind against the pending entries.
+func (h *History) isPending(bvid string, cid int, kind string) bool {
+	for _, entry := range h.pending {
+		if entry.Bvid == bvid && entry.Kind == kind && (cid == 0 || entry.Cid == cid || entry.Cid == 0) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDownloaded checks whether the part cid of bvid is downloaded as a video,
+// cid 0 matches any part. Entries without cid recorded by old versions match
+// all parts.
+func (h *History) IsDownloaded(bvid string, cid int) (bool, error) {
+	return h.IsDownloadedKind(bvid, cid, historyKindVideo)
+}
+
+// IsDownloadedKind is IsDownloaded for downloads of kind.
+func (h *History) IsDownloadedKind(bvid string, cid int, kind string) (ok bool, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.isPending(bvid, cid, kind) {
+		return true, nil
+	}
+	tx := h.db.Where("bvid = ? AND kind = ?", bvid, kind)
+	if cid != 0 {
+		tx = tx.Where("cid IN ?", []int{cid, 0})
+	}
 	var entry HistoryEntry
-	err = h.db.First(&entry, "bvid = ?", bvid).Error
+	err = tx.First(&entry).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			err = nil
@@ -58,11 +331,68 @@ func (h *History) IsDownloaded(bvid string) (ok bool, err error) {
 	return
 }
 
-func (h *History) ExportExcel(filePath string) error {
-	f, err := excelize.OpenFile(filePath)
+// FileOwner returns the bvid of the video downloaded to fileName, relative to
+// the output directory, or "" if history has no such file.
+func (h *History) FileOwner(fileName string) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, entry := range h.pending {
+		if entry.FileName == fileName {
+			return entry.Bvid, nil
+		}
+	}
+	var entry HistoryEntry
+	err := h.db.Where("file_name = ?", fileName).First(&entry).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	return entry.Bvid, nil
+}
+
+var historyColumns = []string{"BVID", "CID", "Kind", "Author", "Title", "Keyword", "Folder", "Tags", "FileName", "DownloadedAt", "FileSize"}
+
+func (e *HistoryEntry) downloadedAt() string {
+	if e.DownloadedAt.IsZero() {
+		return ""
+	}
+	return e.DownloadedAt.Local().Format(time.DateTime)
+}
+
+func (e *HistoryEntry) row() []string {
+	return []string{e.Bvid, strconv.Itoa(e.Cid), e.Kind, e.Author, e.Title, e.Keyword, e.Folder,
+		strings.Join(e.GetTags(), ", "), e.FileName,
+		e.downloadedAt(), strconv.FormatInt(e.FileSize, 10)}
+}
+
+func (h *History) forEach(fn func(entry *HistoryEntry) error) error {
+	rows, err := h.db.Model(&HistoryEntry{}).Rows()
 	if err != nil {
 		return err
 	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var entry HistoryEntry
+		err = h.db.ScanRows(rows, &entry)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (h *History) ExportExcel(filePath string) error {
+	f := excelize.NewFile()
 	defer func() { _ = f.Close() }()
 
 	const sheetName = "History"
@@ -77,46 +407,202 @@ func (h *History) ExportExcel(filePath string) error {
 		return err
 	}
 
-	idx := 1
+	err = setSheetRow(f, sheetName, 1, historyColumns)
+	if err != nil {
+		return err
+	}
+
+	idx := 2
+	err = h.forEach(func(entry *HistoryEntry) error {
+		err := setSheetRow(f, sheetName, idx, entry.row())
+		idx++
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return f.SaveAs(filePath)
+}
+
+func setSheetRow(f *excelize.File, sheetName string, idx int, values []string) error {
 	cell, err := excelize.CoordinatesToCellName(1, idx)
 	if err != nil {
 		return err
 	}
-	idx++
+	return f.SetSheetRow(sheetName, cell, &values)
+}
 
-	err = f.SetSheetRow(sheetName, cell, []interface{}{
-		"BVID", "Author", "Title", "Keyword", "Tags", "FileName",
+func (h *History) ExportCSV(filePath string) error {
+	f, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	w := csv.NewWriter(f)
+	err = w.Write(historyColumns)
+	if err != nil {
+		return err
+	}
+
+	err = h.forEach(func(entry *HistoryEntry) error {
+		return w.Write(entry.row())
 	})
 	if err != nil {
 		return err
 	}
 
-	rows, err := h.db.Model(&HistoryEntry{}).Rows()
+	w.Flush()
+	err = w.Error()
 	if err != nil {
 		return err
 	}
-	defer func() { _ = rows.Close() }()
+	return f.Close()
+}
 
-	for rows.Next() {
-		var entry HistoryEntry
-		err = h.db.ScanRows(rows, &entry)
+func (h *History) ExportJSON(filePath string) error {
+	entries := make([]*HistoryEntry, 0)
+	err := h.forEach(func(entry *HistoryEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	buf, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, buf, 0644)
+}
+
+type HistoryFilter struct {
+	Author  string
+	Keyword string
+	Tag     string
+	Since   time.Time
+	Limit   int
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns with !, which unlike \
+// needs no escaping in string literals of any database.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// whereTag matches entries having tag as a whole element, of the JSON array
+// or of the semicolon separated tags of old versions.
+func whereTag(tx *gorm.DB, tag string) *gorm.DB {
+	quoted, _ := json.Marshal(tag)
+	element := likeEscaper.Replace(string(quoted))
+	legacy := likeEscaper.Replace(tag)
+	return tx.Where("tags LIKE ? ESCAPE '!' OR (tags NOT LIKE '[%' AND "+
+		"(tags = ? OR tags LIKE ? ESCAPE '!' OR tags LIKE ? ESCAPE '!' OR tags LIKE ? ESCAPE '!'))",
+		"%"+element+"%", tag, legacy+";%", "%;"+legacy, "%;"+legacy+";%")
+}
+
+func (h *History) List(filter HistoryFilter) ([]HistoryEntry, error) {
+	tx := h.db.Model(&HistoryEntry{})
+	if filter.Author != "" {
+		tx = tx.Where("author = ?", filter.Author)
+	}
+	if filter.Keyword != "" {
+		tx = tx.Where("keyword = ?", filter.Keyword)
+	}
+	if filter.Tag != "" {
+		tx = whereTag(tx, filter.Tag)
+	}
+	if !filter.Since.IsZero() {
+		tx = tx.Where("downloaded_at >= ?", filter.Since)
+	}
+	// the latest downloads first, so that the limit keeps the same entries
+	// with every database
+	tx = tx.Order("downloaded_at DESC").Order("bvid").Order("cid")
+	if filter.Limit > 0 {
+		tx = tx.Limit(filter.Limit)
+	}
+
+	var entries []HistoryEntry
+	err := tx.Find(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (h *History) Remove(bvid string) (int64, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	tx := h.db.Where("bvid = ?", bvid).Delete(&HistoryEntry{})
+	return tx.RowsAffected, tx.Error
+}
+
+// RemovePart removes the entry of the part cid of bvid downloaded as kind,
+// leaving the other parts.
+func (h *History) RemovePart(bvid string, cid int, kind string) (int64, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	tx := h.db.Where("bvid = ? AND cid = ? AND kind = ?", bvid, cid, kind).Delete(&HistoryEntry{})
+	return tx.RowsAffected, tx.Error
+}
+
+func (h *History) RemoveByKeyword(keyword string) (int64, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	tx := h.db.Where("keyword = ?", keyword).Delete(&HistoryEntry{})
+	return tx.RowsAffected, tx.Error
+}
+
+// HistorySyncReport lists the differences between the output directory and
+// the history.
+type HistorySyncReport struct {
+	// Orphans are files in the output directory not recorded in history
+	Orphans []string
+	// Missing are entries in history whose file is gone
+	Missing []HistoryEntry
+}
+
+// Compare scans outputPath and cross-references the files with the file
+// names in history. Partial downloads and sidecar files are left out.
+func (h *History) Compare(outputPath string) (*HistorySyncReport, error) {
+	files := make(map[string]bool)
+	err := filepath.WalkDir(outputPath, func(path string, entry fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-
-		cell, err = excelize.CoordinatesToCellName(1, idx)
+		if entry.IsDir() || strings.HasSuffix(path, partFileSuffix) || isSidecar(path) {
+			return nil
+		}
+		rel, err := filepath.Rel(outputPath, path)
 		if err != nil {
 			return err
 		}
-		idx++
+		files[filepath.ToSlash(rel)] = false
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		err = f.SetSheetRow(sheetName, cell, []interface{}{
-			entry.Bvid, entry.Author, entry.Title, entry.Keyword, entry.Tags, entry.FileName,
-		})
-		if err != nil {
-			return err
+	report := &HistorySyncReport{}
+	err = h.forEach(func(entry *HistoryEntry) error {
+		name := filepath.ToSlash(entry.FileName)
+		if _, ok := files[name]; ok {
+			files[name] = true
+		} else {
+			report.Missing = append(report.Missing, *entry)
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return rows.Err()
+	for name, recorded := range files {
+		if !recorded {
+			report.Orphans = append(report.Orphans, name)
+		}
+	}
+	sort.Strings(report.Orphans)
+	return report, nil
 }
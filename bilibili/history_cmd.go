@@ -0,0 +1,231 @@
+package bilibili
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/urfave/cli/v3"
+)
+
+func historyFromCliCommand(command *cli.Command) (*History, error) {
+	config, err := LoadConfig(configPathFromCliCommand(command))
+	if err != nil {
+		return nil, err
+	}
+	return NewHistory(config.HistoryDriver, config.HistoryDB)
+}
+
+var historyListCmd = &cli.Command{
+	Name:  "list",
+	Usage: "List downloaded videos",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "config",
+			Aliases: []string{"c"},
+			Value:   "config.yml",
+		},
+		&cli.StringFlag{Name: "author"},
+		&cli.StringFlag{Name: "keyword"},
+		&cli.StringFlag{Name: "tag"},
+		&cli.TimestampFlag{
+			Name:   "since",
+			Usage:  "Only list videos downloaded since this date (YYYY-MM-DD)",
+			Config: cli.TimestampConfig{Layouts: []string{time.DateOnly}},
+		},
+		&cli.IntFlag{Name: "limit"},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "Print entries as JSON",
+		},
+	},
+	Action: func(ctx context.Context, command *cli.Command) error {
+		history, err := historyFromCliCommand(command)
+		if err != nil {
+			return err
+		}
+
+		entries, err := history.List(HistoryFilter{
+			Author:  command.String("author"),
+			Keyword: command.String("keyword"),
+			Tag:     command.String("tag"),
+			Since:   command.Timestamp("since"),
+			Limit:   command.Int("limit"),
+		})
+		if err != nil {
+			return err
+		}
+
+		if command.Bool("json") {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(entries)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "BVID\tCID\tKind\tAuthor\tTitle\tKeyword\tFolder\tFileName\tDownloadedAt\tFileSize")
+		for _, entry := range entries {
+			_, _ = fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\n",
+				entry.Bvid, entry.Cid, entry.Kind, entry.Author, entry.Title, entry.Keyword, entry.Folder, entry.FileName,
+				entry.downloadedAt(), entry.FileSize)
+		}
+		return w.Flush()
+	},
+}
+
+var historyRemoveCmd = &cli.Command{
+	Name:  "remove",
+	Usage: "Remove entries from history so they will be downloaded again",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "config",
+			Aliases: []string{"c"},
+			Value:   "config.yml",
+		},
+		&cli.StringFlag{Name: "bvid"},
+		&cli.StringFlag{Name: "keyword"},
+	},
+	Action: func(ctx context.Context, command *cli.Command) error {
+		bvid := command.String("bvid")
+		keyword := command.String("keyword")
+		if bvid == "" && keyword == "" {
+			return errors.New("bvid/keyword is required")
+		}
+
+		history, err := historyFromCliCommand(command)
+		if err != nil {
+			return err
+		}
+
+		var count int64
+		if bvid != "" {
+			count, err = history.Remove(bvid)
+		} else {
+			count, err = history.RemoveByKeyword(keyword)
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Removed %d entries\n", count)
+		return nil
+	},
+}
+
+var historyExportCmd = &cli.Command{
+	Name:  "export",
+	Usage: "Export history to a file",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "config",
+			Aliases: []string{"c"},
+			Value:   "config.yml",
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "Export format, csv, json or xlsx",
+			Value: "xlsx",
+		},
+		&cli.StringFlag{
+			Name:     "output",
+			Aliases:  []string{"o"},
+			Required: true,
+		},
+	},
+	Action: func(ctx context.Context, command *cli.Command) error {
+		history, err := historyFromCliCommand(command)
+		if err != nil {
+			return err
+		}
+
+		output := command.String("output")
+		switch format := command.String("format"); format {
+		case "csv":
+			return history.ExportCSV(output)
+		case "json":
+			return history.ExportJSON(output)
+		case "xlsx":
+			return history.ExportExcel(output)
+		default:
+			return errors.Newf("invalid format: %s", format)
+		}
+	},
+}
+
+var historySyncCmd = &cli.Command{
+	Name:  "sync",
+	Usage: "Compare the output directory with history",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "config",
+			Aliases: []string{"c"},
+			Value:   "config.yml",
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "Output directory, defaults to the one in config",
+		},
+		&cli.BoolFlag{
+			Name:  "fix",
+			Usage: "Remove entries whose file is missing so they will be downloaded again",
+		},
+	},
+	Action: func(ctx context.Context, command *cli.Command) error {
+		config, err := LoadConfig(configPathFromCliCommand(command))
+		if err != nil {
+			return err
+		}
+		history, err := NewHistory(config.HistoryDriver, config.HistoryDB)
+		if err != nil {
+			return err
+		}
+
+		output := config.Output
+		if command.IsSet("output") {
+			output = command.String("output")
+		}
+
+		report, err := history.Compare(output)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range report.Orphans {
+			fmt.Printf("Orphan file: %s\n", name)
+		}
+		for _, entry := range report.Missing {
+			fmt.Printf("Missing file: %s (%s)\n", entry.FileName, entry.Bvid)
+		}
+		fmt.Printf("%d orphan files, %d missing files\n", len(report.Orphans), len(report.Missing))
+
+		if !command.Bool("fix") {
+			return nil
+		}
+		count := int64(0)
+		for _, entry := range report.Missing {
+			n, err := history.RemovePart(entry.Bvid, entry.Cid, entry.Kind)
+			if err != nil {
+				return err
+			}
+			count += n
+		}
+		fmt.Printf("Removed %d entries\n", count)
+		return nil
+	},
+}
+
+var historyCmd = &cli.Command{
+	Name:  "history",
+	Usage: "Manage download history",
+	Commands: []*cli.Command{
+		historyListCmd,
+		historyRemoveCmd,
+		historyExportCmd,
+		historySyncCmd,
+	},
+}
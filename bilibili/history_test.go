@@ -0,0 +1,461 @@
+package bilibili
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestHistory(t *testing.T) *History {
+	h, err := NewHistory("", filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []*HistoryEntry{
+		{Bvid: "BV1y7411Q7Eq", Author: "a", Keyword: "k1", Tags: "x;y"},
+		{Bvid: "BV17x411w7KC", Author: "a", Keyword: "k2", Tags: "y;z"},
+		{Bvid: "BV1Q541167Qg", Author: "b", Keyword: "k1", Tags: "z"},
+	} {
+		err = h.Save(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return h
+}
+
+// newTestDownloader creates a downloader writing to a temporary directory with
+// a fake ffmpeg, configure adjusts the config before the downloader is created.
+func newTestDownloader(t *testing.T, configure ...func(config *Config)) *Downloader {
+	dir := t.TempDir()
+	config := defaultConfig()
+	config.Output = dir
+	config.HistoryDB = filepath.Join(dir, "history.db")
+	config.FFmpeg = writeFakeFFmpeg(t, t.TempDir())
+	for _, fn := range configure {
+		fn(config)
+	}
+	d, err := NewDownloaderFromConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestHistoryList(t *testing.T) {
+	h := newTestHistory(t)
+	entry := &HistoryEntry{Bvid: "BV1GJ411x7h7", Author: "c", Keyword: "k3"}
+	entry.SetTags([]string{"cat", "100%"})
+	err := h.Save(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		filter HistoryFilter
+		count  int
+	}{
+		{filter: HistoryFilter{}, count: 4},
+		{filter: HistoryFilter{Author: "a"}, count: 2},
+		{filter: HistoryFilter{Author: "a", Keyword: "k1"}, count: 1},
+		{filter: HistoryFilter{Tag: "z"}, count: 2},
+		{filter: HistoryFilter{Tag: "y"}, count: 2},
+		{filter: HistoryFilter{Tag: "cat"}, count: 1},
+		{filter: HistoryFilter{Tag: "a"}, count: 0},
+		{filter: HistoryFilter{Tag: "%"}, count: 0},
+		{filter: HistoryFilter{Tag: "100%"}, count: 1},
+		{filter: HistoryFilter{Limit: 1}, count: 1},
+		{filter: HistoryFilter{Since: time.Now().Add(-time.Hour)}, count: 4},
+		{filter: HistoryFilter{Since: time.Now().Add(time.Hour)}, count: 0},
+	} {
+		entries, err := h.List(test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != test.count {
+			t.Errorf("List(%+v) = %d entries, want %d", test.filter, len(entries), test.count)
+		}
+	}
+
+	entries, err := h.List(HistoryFilter{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Bvid != entry.Bvid {
+		t.Errorf("List() with limit 1 = %+v, want the latest entry %s", entries, entry.Bvid)
+	}
+}
+
+func TestHistoryRemove(t *testing.T) {
+	h := newTestHistory(t)
+
+	count, err := h.Remove("BV1y7411Q7Eq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("Remove() = %d, want 1", count)
+	}
+
+	count, err = h.RemoveByKeyword("k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("RemoveByKeyword() = %d, want 1", count)
+	}
+
+	ok, err := h.IsDownloaded("BV17x411w7KC", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("unrelated entry was removed")
+	}
+}
+
+func TestHistoryExportCSV(t *testing.T) {
+	h := newTestHistory(t)
+	filePath := filepath.Join(t.TempDir(), "history.csv")
+	err := h.ExportCSV(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 {
+		t.Fatalf("got %d records, want 4", len(records))
+	}
+	if !slices.Equal(records[0], historyColumns) {
+		t.Errorf("header = %v, want %v", records[0], historyColumns)
+	}
+}
+
+func TestHistoryExportJSON(t *testing.T) {
+	h := newTestHistory(t)
+	filePath := filepath.Join(t.TempDir(), "history.json")
+	err := h.ExportJSON(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []HistoryEntry
+	err = json.Unmarshal(buf, &entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+}
+
+func TestHistoryExportExcel(t *testing.T) {
+	h := newTestHistory(t)
+	filePath := filepath.Join(t.TempDir(), "history.xlsx")
+	err := h.ExportExcel(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := excelize.OpenFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	rows, err := f.GetRows("History")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("got %d rows, want 4", len(rows))
+	}
+	if !slices.Equal(rows[0], historyColumns) {
+		t.Errorf("header = %v, want %v", rows[0], historyColumns)
+	}
+	if rows[1][0] != "BV1y7411Q7Eq" {
+		t.Errorf("first row bvid = %s", rows[1][0])
+	}
+	if slices.Contains(f.GetSheetList(), "Sheet1") {
+		t.Error("default sheet was not deleted")
+	}
+}
+
+func TestHistoryCompare(t *testing.T) {
+	h := newTestHistory(t)
+	output := t.TempDir()
+
+	err := h.Save(&HistoryEntry{Bvid: "BV1GJ411x7h7", FileName: "a/kept.mp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a/kept.mp4", "a/kept" + infoJSONExt, "a/kept" + descriptionExt, "orphan.mp4",
+		"downloading.mp4" + partFileSuffix} {
+		path := filepath.Join(output, name)
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := h.Compare(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(report.Orphans, []string{"orphan.mp4"}) {
+		t.Errorf("orphans = %v", report.Orphans)
+	}
+	if len(report.Missing) != 3 {
+		t.Errorf("missing = %v", report.Missing)
+	}
+}
+
+func TestHistoryParts(t *testing.T) {
+	h := newTestHistory(t)
+	for _, cid := range []int{100, 200} {
+		err := h.Save(&HistoryEntry{Bvid: "BV1GJ411x7h7", Cid: cid})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		cid int
+		ok  bool
+	}{
+		{cid: 0, ok: true},
+		{cid: 100, ok: true},
+		{cid: 200, ok: true},
+		{cid: 300, ok: false},
+	} {
+		ok, err := h.IsDownloaded("BV1GJ411x7h7", test.cid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != test.ok {
+			t.Errorf("IsDownloaded(cid %d) = %v, want %v", test.cid, ok, test.ok)
+		}
+	}
+}
+
+func TestHistoryRemovePart(t *testing.T) {
+	h := newTestHistory(t)
+	for _, cid := range []int{100, 200} {
+		err := h.Save(&HistoryEntry{Bvid: "BV1GJ411x7h7", Cid: cid})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, err := h.RemovePart("BV1GJ411x7h7", 100, historyKindVideo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("RemovePart() = %d, want 1", count)
+	}
+	for cid, want := range map[int]bool{100: false, 200: true} {
+		ok, err := h.IsDownloaded("BV1GJ411x7h7", cid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != want {
+			t.Errorf("IsDownloaded(cid %d) = %v, want %v", cid, ok, want)
+		}
+	}
+}
+
+func TestHistoryKinds(t *testing.T) {
+	h := newTestHistory(t)
+	err := h.Save(&HistoryEntry{Bvid: "BV1GJ411x7h7", Cid: 100, Kind: historyKindAudio})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := h.IsDownloaded("BV1GJ411x7h7", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("audio only download should not count as the video")
+	}
+	ok, err = h.IsDownloadedKind("BV1GJ411x7h7", 100, historyKindAudio)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("IsDownloadedKind(audio) = false, want true")
+	}
+
+	err = h.Save(&HistoryEntry{Bvid: "BV1GJ411x7h7", Cid: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := h.List(HistoryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 5 {
+		t.Errorf("List() = %d entries, want 5", len(entries))
+	}
+}
+
+func TestHistoryMigrateLegacy(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "history.db")
+	db, err := gorm.Open(sqlite.Open(dsn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Exec("CREATE TABLE history_entries (bvid text, author text, title text, keyword text, folder text, tags text, file_name text)").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Exec("INSERT INTO history_entries (bvid, title) VALUES ('BV1GJ411x7h7', 'a'), ('BV1GJ411x7h7', 'a'), ('BV1y7411Q7Eq', 'b')").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := NewHistory(historyDriverSqlite, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := h.List(HistoryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("migrated %d entries, want 2", len(entries))
+	}
+
+	ok, err := h.IsDownloaded("BV1GJ411x7h7", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("legacy entry should match any part")
+	}
+}
+
+func TestHistorySaveBatch(t *testing.T) {
+	h := newTestHistory(t)
+	err := h.SaveBatch([]*HistoryEntry{
+		{Bvid: "BV1GJ411x7h7", Cid: 1, Title: "old"},
+		{Bvid: "BV1GJ411x7h7", Cid: 2},
+		{Bvid: "BV1GJ411x7h7", Cid: 1, Title: "new"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := h.List(HistoryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 5 {
+		t.Fatalf("List() = %d entries, want 5", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.Bvid == "BV1GJ411x7h7" && entry.Cid == 1 && entry.Title != "new" {
+			t.Errorf("title of duplicated entry = %q, want the last one", entry.Title)
+		}
+	}
+}
+
+func TestHistoryBufferSaves(t *testing.T) {
+	h := newTestHistory(t)
+	h.BufferSaves(2)
+
+	err := h.Save(&HistoryEntry{Bvid: "BV1GJ411x7h7", Cid: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := h.IsDownloaded("BV1GJ411x7h7", 1)
+	if err != nil || !ok {
+		t.Errorf("IsDownloaded of pending entry = %v, %v, want true", ok, err)
+	}
+	entries, err := h.List(HistoryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("List() before flush = %d entries, want 3", len(entries))
+	}
+
+	err = h.Save(&HistoryEntry{Bvid: "BV1GJ411x7h7", Cid: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.Save(&HistoryEntry{Bvid: "BV1GJ411x7h7", Cid: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err = h.List(HistoryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 5 {
+		t.Errorf("List() after filling the buffer = %d entries, want 5", len(entries))
+	}
+
+	err = h.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err = h.List(HistoryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 6 {
+		t.Errorf("List() after flush = %d entries, want 6", len(entries))
+	}
+}
+
+func TestHistoryEntryTags(t *testing.T) {
+	tags := []string{"a;b", "c,d", `"e"`}
+	var entry HistoryEntry
+	entry.SetTags(tags)
+	if got := entry.GetTags(); !slices.Equal(got, tags) {
+		t.Errorf("GetTags() = %q, want %q", got, tags)
+	}
+
+	buf, err := json.Marshal(&entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded HistoryEntry
+	err = json.Unmarshal(buf, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decoded.GetTags(); !slices.Equal(got, tags) {
+		t.Errorf("tags after JSON round trip = %q, want %q", got, tags)
+	}
+
+	legacy := HistoryEntry{Tags: "x;y;"}
+	if got := legacy.GetTags(); !slices.Equal(got, []string{"x", "y"}) {
+		t.Errorf("GetTags() of semicolon joined tags = %q", got)
+	}
+}
@@ -0,0 +1,108 @@
+package bilibili
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/CuteReimu/bilibili/v2"
+)
+
+// videoInfoJSON is the .info.json written by --write-info-json, with the
+// field names of yt-dlp so that tools reading its archives work with it. ID
+// is the bvid.
+type videoInfoJSON struct {
+	ID          string   `json:"id"`
+	Cid         int      `json:"cid"`
+	Title       string   `json:"title"`
+	Uploader    string   `json:"uploader"`
+	UploaderID  int      `json:"uploader_id"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+	Categories  []string `json:"categories,omitempty"`
+	Duration    int      `json:"duration"`
+	Timestamp   int      `json:"timestamp"`
+	UploadDate  string   `json:"upload_date"`
+	WebpageURL  string   `json:"webpage_url"`
+}
+
+func newVideoInfoJSON(option DownloadOption, info *bilibili.VideoInfo) *videoInfoJSON {
+	duration := info.Duration
+	for _, page := range info.Pages {
+		if page.Cid == option.Cid && len(info.Pages) > 1 {
+			duration = page.Duration
+		}
+	}
+	v := &videoInfoJSON{
+		ID:          info.Bvid,
+		Cid:         option.Cid,
+		Title:       info.Title,
+		Uploader:    info.Owner.Name,
+		UploaderID:  info.Owner.Mid,
+		Description: info.Desc,
+		Tags:        option.Tags,
+		Duration:    duration,
+		Timestamp:   info.Pubdate,
+		UploadDate:  time.Unix(int64(info.Pubdate), 0).Format("20060102"),
+		WebpageURL:  "https://www.bilibili.com/video/" + info.Bvid,
+	}
+	if v.Tags == nil {
+		v.Tags = []string{}
+	}
+	if info.Tname != "" {
+		v.Categories = []string{info.Tname}
+	}
+	return v
+}
+
+// Extensions of the sidecar files written next to merged files.
+const (
+	infoJSONExt    = ".info.json"
+	descriptionExt = ".description"
+)
+
+// isSidecar reports whether path is a sidecar file.
+func isSidecar(path string) bool {
+	return strings.HasSuffix(path, infoJSONExt) || strings.HasSuffix(path, descriptionExt)
+}
+
+// sidecarPath returns the file next to the merged file with its extension
+// replaced by ext.
+func sidecarPath(filePath string, ext string) string {
+	return strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ext
+}
+
+// writeSidecars writes the .info.json and .description files of the merged
+// file at filePath if they are enabled. The video is downloaded already, so
+// failures are only logged.
+func (d *Downloader) writeSidecars(ctx context.Context, option DownloadOption, filePath string) {
+	if !d.writeInfoJSON && !d.writeDescription {
+		return
+	}
+	info, err := d.GetVideoInfo(ctx, option.Bvid)
+	if err != nil {
+		zap.L().Warn("Get video info for sidecar files failed", zap.String("bvid", option.Bvid), zap.Error(err))
+		return
+	}
+
+	if d.writeInfoJSON {
+		buf, err := json.MarshalIndent(newVideoInfoJSON(option, info), "", "  ")
+		if err == nil {
+			err = os.WriteFile(sidecarPath(filePath, infoJSONExt), buf, 0644)
+		}
+		if err != nil {
+			zap.L().Warn("Write info json failed", zap.String("bvid", option.Bvid), zap.Error(err))
+		}
+	}
+	if d.writeDescription {
+		err = os.WriteFile(sidecarPath(filePath, descriptionExt), []byte(info.Desc), 0644)
+		if err != nil {
+			zap.L().Warn("Write description failed", zap.String("bvid", option.Bvid), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package bilibili
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CuteReimu/bilibili/v2"
+)
+
+func TestNewVideoInfoJSON(t *testing.T) {
+	info := &bilibili.VideoInfo{
+		Bvid:     "BV1y7411Q7Eq",
+		Title:    "t",
+		Desc:     "d",
+		Tname:    "c",
+		Pubdate:  1577836800,
+		Duration: 300,
+		Owner:    bilibili.Owner{Mid: 1, Name: "a"},
+		Pages:    []bilibili.VideoPage{{Cid: 10, Duration: 100}, {Cid: 20, Duration: 200}},
+	}
+	v := newVideoInfoJSON(DownloadOption{Cid: 20, Tags: []string{"x"}}, info)
+	if v.ID != info.Bvid || v.Cid != 20 || v.Uploader != "a" || v.Description != "d" {
+		t.Errorf("info json = %+v", v)
+	}
+	if v.Duration != 200 {
+		t.Errorf("Duration = %d, want the duration of the part", v.Duration)
+	}
+	if len(v.Tags) != 1 || len(v.Categories) != 1 || v.WebpageURL != "https://www.bilibili.com/video/BV1y7411Q7Eq" {
+		t.Errorf("info json = %+v", v)
+	}
+	if want := time.Unix(1577836800, 0).Format("20060102"); v.UploadDate != want {
+		t.Errorf("UploadDate = %s, want %s", v.UploadDate, want)
+	}
+
+	if got := sidecarPath("out/a - t.mp4", ".info.json"); got != "out/a - t.info.json" {
+		t.Errorf("sidecarPath() = %s", got)
+	}
+}
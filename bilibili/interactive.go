@@ -0,0 +1,163 @@
+package bilibili
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"go.uber.org/zap"
+
+	"github.com/CuteReimu/bilibili/v2"
+)
+
+// ErrNotPublished is returned for scheduled premieres which can't be watched
+// yet.
+var ErrNotPublished = errors.New("not yet published")
+
+// maxInteractiveBranches limits the branches of an interactive video
+// downloaded by --all-branches, some graphs are huge.
+const maxInteractiveBranches = 200
+
+// checkPublished fails with ErrNotPublished if info is a premiere scheduled
+// after now.
+func checkPublished(info *bilibili.VideoInfo, now time.Time) error {
+	pubdate := time.Unix(int64(info.Pubdate), 0)
+	if info.Pubdate > 0 && pubdate.After(now) {
+		return errors.Wrapf(ErrNotPublished, "premiere at %s, bvid: %s", pubdate.Format(time.DateTime), info.Bvid)
+	}
+	return nil
+}
+
+type playerInfo struct {
+	Interaction struct {
+		GraphVersion int `json:"graph_version"`
+	} `json:"interaction"`
+}
+
+// steinEdgeInfo is a node of the graph of an interactive video, with the
+// choices leading to the next nodes.
+type steinEdgeInfo struct {
+	Title  string `json:"title"`
+	EdgeId int    `json:"edge_id"`
+	Edges  struct {
+		Questions []struct {
+			Choices []steinChoice `json:"choices"`
+		} `json:"questions"`
+	} `json:"edges"`
+}
+
+// steinChoice leads to the edge Id, which plays Cid.
+type steinChoice struct {
+	Id     int    `json:"id"`
+	Cid    int    `json:"cid"`
+	Option string `json:"option"`
+}
+
+type interactiveBranch struct {
+	Cid   int
+	Title string
+}
+
+// collectBranches walks the graph of an interactive video from the root node
+// of rootCid, fetch returns the node of an edge and 0 means the root.
+func collectBranches(rootCid int, fetch func(edgeID int) (*steinEdgeInfo, error)) ([]interactiveBranch, error) {
+	type node struct {
+		edgeID int
+		cid    int
+	}
+	seen := map[int]bool{rootCid: true}
+	queue := []node{{edgeID: 0, cid: rootCid}}
+	branches := make([]interactiveBranch, 0)
+	for len(queue) > 0 && len(branches) < maxInteractiveBranches {
+		n := queue[0]
+		queue = queue[1:]
+		info, err := fetch(n.edgeID)
+		if err != nil {
+			return nil, err
+		}
+		branches = append(branches, interactiveBranch{Cid: n.cid, Title: info.Title})
+		for _, q := range info.Edges.Questions {
+			for _, c := range q.Choices {
+				if c.Cid == 0 || seen[c.Cid] {
+					continue
+				}
+				seen[c.Cid] = true
+				queue = append(queue, node{edgeID: c.Id, cid: c.Cid})
+			}
+		}
+	}
+	if len(queue) > 0 {
+		zap.L().Warn("Interactive video has too many branches, download some of them",
+			zap.Int("branches", len(branches)))
+	}
+	return branches, nil
+}
+
+func (d *Downloader) getInteractiveBranches(ctx context.Context, bvid string, cid int) ([]interactiveBranch, error) {
+	player, err := retryAPI(ctx, d.maxRetries, func() (*playerInfo, error) {
+		client, err := d.GetClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return getResponseData[playerInfo](client, "https://api.bilibili.com/x/player/wbi/v2", map[string]string{
+			"bvid": bvid,
+			"cid":  strconv.Itoa(cid),
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "get graph version, bvid: %s", bvid)
+	}
+
+	return collectBranches(cid, func(edgeID int) (*steinEdgeInfo, error) {
+		params := map[string]string{
+			"bvid":          bvid,
+			"graph_version": strconv.Itoa(player.Interaction.GraphVersion),
+		}
+		if edgeID != 0 {
+			params["edge_id"] = strconv.Itoa(edgeID)
+		}
+		return retryAPI(ctx, d.maxRetries, func() (*steinEdgeInfo, error) {
+			client, err := d.GetClient(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return getResponseData[steinEdgeInfo](client, "https://api.bilibili.com/x/stein/edgeinfo_v2", params)
+		})
+	})
+}
+
+// downloadBranches downloads every branch of an interactive video as a part,
+// a failed branch doesn't stop the others.
+func (d *Downloader) downloadBranches(ctx context.Context, option DownloadOption, saveHistory bool) error {
+	branches, err := d.getInteractiveBranches(ctx, option.Bvid, option.Cid)
+	if err != nil {
+		return err
+	}
+	zap.L().Info("Download branches of interactive video", zap.String("bvid", option.Bvid),
+		zap.Int("branches", len(branches)))
+
+	var firstErr error
+	for i, branch := range branches {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		branchOption := option
+		branchOption.Cid = branch.Cid
+		branchOption.Interactive = false
+		branchOption.Title = fmt.Sprintf("%s - B%d %s", option.Title, i+1, branch.Title)
+		if option.OutputName != "" {
+			branchOption.OutputName = fmt.Sprintf("%s - B%d", option.OutputName, i+1)
+		}
+		err = d.Download(ctx, branchOption, false, saveHistory)
+		if err != nil {
+			zap.L().Error("Download branch failed", zap.String("bvid", option.Bvid), zap.Int("cid", branch.Cid),
+				zap.Error(err))
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,60 @@
+package bilibili
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/CuteReimu/bilibili/v2"
+)
+
+func TestCheckPublished(t *testing.T) {
+	now := time.Now()
+	for _, test := range []struct {
+		pubdate   time.Time
+		published bool
+	}{
+		{pubdate: now.Add(-time.Hour), published: true},
+		{pubdate: now.Add(time.Hour), published: false},
+	} {
+		info := &bilibili.VideoInfo{Bvid: "BV1y7411Q7Eq", Pubdate: int(test.pubdate.Unix())}
+		err := checkPublished(info, now)
+		if errors.Is(err, ErrNotPublished) == test.published {
+			t.Errorf("checkPublished(pubdate %s) = %v", test.pubdate, err)
+		}
+	}
+}
+
+func TestCollectBranches(t *testing.T) {
+	edge := func(title string, choices ...steinChoice) *steinEdgeInfo {
+		info := &steinEdgeInfo{Title: title}
+		info.Edges.Questions = append(info.Edges.Questions, struct {
+			Choices []steinChoice `json:"choices"`
+		}{Choices: choices})
+		return info
+	}
+	// edge 3 loops back to the root and edge 5 leads to the cid of edge 1
+	graph := map[int]*steinEdgeInfo{
+		0: edge("root", steinChoice{Id: 1, Cid: 101}, steinChoice{Id: 2, Cid: 102}),
+		1: edge("left", steinChoice{Id: 3, Cid: 100}),
+		2: edge("right", steinChoice{Id: 4, Cid: 103}, steinChoice{Id: 5, Cid: 101}),
+		4: edge("end"),
+	}
+
+	branches, err := collectBranches(100, func(edgeID int) (*steinEdgeInfo, error) {
+		info, ok := graph[edgeID]
+		if !ok {
+			t.Fatalf("fetched unexpected edge %d", edgeID)
+		}
+		return info, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interactiveBranch{{100, "root"}, {101, "left"}, {102, "right"}, {103, "end"}}
+	if !slices.Equal(branches, want) {
+		t.Errorf("collectBranches() = %v, want %v", branches, want)
+	}
+}
@@ -0,0 +1,102 @@
+package bilibili
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/go-resty/resty/v2"
+	"github.com/urfave/cli/v3"
+	"go.uber.org/zap"
+)
+
+const notifyTimeout = 10 * time.Second
+
+const telegramAPI = "https://api.telegram.org"
+
+// telegramMaxTextLength is the limit of sendMessage, in characters. Bytes
+// are counted instead, which are never fewer.
+const telegramMaxTextLength = 4096
+
+// maxTextFailures limits the failures listed by Text, the report has all.
+const maxTextFailures = 10
+
+// Text describes the summary in a few lines for chat notifications.
+func (s *BatchSummary) Text() string {
+	var sb strings.Builder
+	status := "completed"
+	if len(s.Failed) > 0 {
+		status = "completed with failures"
+	}
+	_, _ = fmt.Fprintf(&sb, "media-collector batch %s\n", status)
+	_, _ = fmt.Fprintf(&sb, "candidates: %d, downloaded: %d, skipped: %d, not started: %d, restricted: %d, failed: %d, bytes: %d",
+		s.Candidates, s.Downloaded, s.AlreadyDownloaded, s.NotStarted, len(s.Restricted), len(s.Failed), s.TotalBytes)
+	for _, f := range s.Failed[:min(len(s.Failed), maxTextFailures)] {
+		_, _ = fmt.Fprintf(&sb, "\n%s %s: %v", f.Bvid, f.Title, f.Err)
+	}
+	if n := len(s.Failed) - maxTextFailures; n > 0 {
+		_, _ = fmt.Fprintf(&sb, "\nand %d more", n)
+	}
+	return sb.String()
+}
+
+func checkNotifyResponse(rsp *resty.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	if rsp.IsError() {
+		return errors.Newf("status: %s, body: %s", rsp.Status(), rsp.String())
+	}
+	return nil
+}
+
+// notifyWebhook posts the JSON report of summary to url.
+func notifyWebhook(ctx context.Context, client *resty.Client, url string, summary *BatchSummary) error {
+	return checkNotifyResponse(client.R().SetContext(ctx).SetBody(summary.report()).Post(url))
+}
+
+// notifyTelegram sends the text of summary to chatID with the bot of token,
+// through the Bot API at apiURL. The token is part of the URL, so it's
+// redacted from errors.
+func notifyTelegram(ctx context.Context, client *resty.Client, apiURL string, token string, chatID string,
+	summary *BatchSummary) error {
+	err := checkNotifyResponse(client.R().SetContext(ctx).
+		SetBody(map[string]string{
+			"chat_id": chatID,
+			"text":    truncateString(summary.Text(), telegramMaxTextLength),
+		}).
+		Post(apiURL + "/bot" + token + "/sendMessage"))
+	if err != nil {
+		return errors.New(strings.ReplaceAll(err.Error(), token, "<token>"))
+	}
+	return nil
+}
+
+// notifyBatch sends summary to the notifications configured by the flags of
+// command, failed notifications are logged but don't fail the batch. The
+// batch may have been interrupted, so they don't share its context.
+func notifyBatch(command *cli.Command, summary *BatchSummary) {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	client := resty.New()
+
+	if url := command.String("notify-webhook"); url != "" {
+		err := notifyWebhook(ctx, client, url, summary)
+		if err != nil {
+			zap.L().Error("Notify webhook failed", zap.Error(err))
+		}
+	}
+
+	token := command.String("notify-telegram-token")
+	chatID := command.String("notify-telegram-chat")
+	if token != "" && chatID != "" {
+		err := notifyTelegram(ctx, client, telegramAPI, token, chatID, summary)
+		if err != nil {
+			zap.L().Error("Notify telegram failed", zap.Error(err))
+		}
+	} else if token != "" || chatID != "" {
+		zap.L().Warn("Telegram notification needs both --notify-telegram-token and --notify-telegram-chat")
+	}
+}
@@ -0,0 +1,95 @@
+package bilibili
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/go-resty/resty/v2"
+)
+
+func TestNotify(t *testing.T) {
+	summary := &BatchSummary{
+		Candidates: 3,
+		Downloaded: 1,
+		Failed:     []BatchFailure{{Bvid: "BV1y7411Q7Eq", Title: "t", Err: errors.New("boom")}},
+	}
+
+	var webhook batchReport
+	var telegram map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		switch r.URL.Path {
+		case "/webhook":
+			err = json.NewDecoder(r.Body).Decode(&webhook)
+		case "/botTOKEN/sendMessage":
+			err = json.NewDecoder(r.Body).Decode(&telegram)
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	client := resty.New()
+	err := notifyWebhook(ctx, client, server.URL+"/webhook", summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if webhook.Candidates != 3 || len(webhook.Failed) != 1 || webhook.Failed[0].Error != "boom" {
+		t.Errorf("webhook got %+v", webhook)
+	}
+
+	err = notifyTelegram(ctx, client, server.URL, "TOKEN", "42", summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if telegram["chat_id"] != "42" || !strings.Contains(telegram["text"], "BV1y7411Q7Eq t: boom") {
+		t.Errorf("telegram got %+v", telegram)
+	}
+
+	long := &BatchSummary{Failed: []BatchFailure{{Bvid: "BV1y7411Q7Eq", Err: errors.New(strings.Repeat("错", 5000))}}}
+	err = notifyTelegram(ctx, client, server.URL, "TOKEN", "42", long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(telegram["text"]); n > telegramMaxTextLength {
+		t.Errorf("telegram text is %d bytes, want at most %d", n, telegramMaxTextLength)
+	}
+
+	err = notifyWebhook(ctx, client, server.URL+"/missing", summary)
+	if err == nil {
+		t.Error("notifyWebhook of a missing URL succeeded")
+	}
+
+	server.Close()
+	err = notifyTelegram(ctx, client, server.URL, "TOKEN", "42", summary)
+	if err == nil {
+		t.Fatal("notifyTelegram of a closed server succeeded")
+	}
+	if strings.Contains(err.Error(), "TOKEN") {
+		t.Errorf("error leaks the token: %v", err)
+	}
+}
+
+func TestBatchSummaryText(t *testing.T) {
+	summary := &BatchSummary{}
+	for range maxTextFailures + 5 {
+		summary.Failed = append(summary.Failed, BatchFailure{Bvid: "BV1y7411Q7Eq", Title: "t", Err: errors.New("boom")})
+	}
+	text := summary.Text()
+	if n := strings.Count(text, "boom"); n != maxTextFailures {
+		t.Errorf("Text() lists %d failures, want %d", n, maxTextFailures)
+	}
+	if !strings.HasSuffix(text, "and 5 more") {
+		t.Errorf("Text() doesn't count the rest:\n%s", text)
+	}
+}
@@ -0,0 +1,141 @@
+package bilibili
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"go.uber.org/zap"
+)
+
+// A proxy failing proxyMaxFailures requests in a row is ejected from the pool
+// for proxyEjectDuration.
+const (
+	proxyMaxFailures   = 3
+	proxyEjectDuration = 5 * time.Minute
+)
+
+type poolProxy struct {
+	url          *url.URL
+	transport    *http.Transport
+	failures     int
+	ejectedUntil time.Time
+}
+
+// proxyPool is an http.RoundTripper sending every request through the next
+// proxy of the pool in turn. API requests rejected with 403 or 429 are sent
+// again through the next proxy if they have no body. Rejected downloads
+// don't count against the proxy, the CDN rejects expired signed URLs with
+// 403 whichever proxy they come from.
+type proxyPool struct {
+	mu      sync.Mutex
+	proxies []*poolProxy
+	next    int
+	now     func() time.Time
+}
+
+func newProxyPool(rawURLs []string) (*proxyPool, error) {
+	pool := &proxyPool{now: time.Now}
+	for _, rawURL := range rawURLs {
+		rawURL = strings.TrimSpace(rawURL)
+		if rawURL == "" {
+			continue
+		}
+		u, err := url.Parse(rawURL)
+		if err != nil || u.Host == "" {
+			return nil, errors.Newf("invalid proxy: %s, should be like http://host:port or socks5://host:port", rawURL)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, errors.Newf("invalid proxy: %s, scheme should be http, https or socks5", rawURL)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(u)
+		pool.proxies = append(pool.proxies, &poolProxy{url: u, transport: transport})
+	}
+	if len(pool.proxies) == 0 {
+		return nil, errors.New("proxy list is empty")
+	}
+	return pool, nil
+}
+
+// pick returns the next proxy not ejected, or the one coming back soonest if
+// all of them are.
+func (p *proxyPool) pick() *poolProxy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	for i := range p.proxies {
+		proxy := p.proxies[(p.next+i)%len(p.proxies)]
+		if !now.Before(proxy.ejectedUntil) {
+			p.next = (p.next + i + 1) % len(p.proxies)
+			return proxy
+		}
+	}
+	soonest := p.proxies[0]
+	for _, proxy := range p.proxies[1:] {
+		if proxy.ejectedUntil.Before(soonest.ejectedUntil) {
+			soonest = proxy
+		}
+	}
+	return soonest
+}
+
+func (p *proxyPool) report(proxy *poolProxy, failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !failed {
+		proxy.failures = 0
+		return
+	}
+	proxy.failures++
+	if proxy.failures >= proxyMaxFailures {
+		proxy.failures = 0
+		proxy.ejectedUntil = p.now().Add(proxyEjectDuration)
+		zap.L().Warn("Proxy keeps failing, eject it for a while",
+			zap.String("proxy", proxy.url.Redacted()), zap.Duration("duration", proxyEjectDuration))
+	}
+}
+
+func (p *proxyPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	hasBody := req.Body != nil && req.Body != http.NoBody
+	api := isAPIHost(req.URL.Hostname())
+	for attempt := 1; ; attempt++ {
+		proxy := p.pick()
+		// a RoundTripper must not modify the request, and each attempt
+		// needs its own
+		rsp, err := proxy.transport.RoundTrip(req.Clone(req.Context()))
+		rejected := err == nil && api &&
+			(rsp.StatusCode == http.StatusForbidden || rsp.StatusCode == http.StatusTooManyRequests)
+		p.report(proxy, err != nil || rejected)
+		if !rejected || hasBody || attempt >= len(p.proxies) {
+			return rsp, err
+		}
+		_ = rsp.Body.Close()
+		zap.L().Debug("Request rejected, try the next proxy",
+			zap.String("proxy", proxy.url.Redacted()), zap.Int("status", rsp.StatusCode))
+	}
+}
+
+// isAPIHost reports whether host serves the API rather than the streams,
+// which come from CDN hosts like upos-sz-mirrorcos.bilivideo.com.
+func isAPIHost(host string) bool {
+	return host == cookieDomain || strings.HasSuffix(host, "."+cookieDomain)
+}
+
+// setProxies sends the requests of d through the proxies in turn.
+func (d *Downloader) setProxies(proxies []string) error {
+	pool, err := newProxyPool(proxies)
+	if err != nil {
+		return err
+	}
+	d.client.Resty().SetTransport(pool)
+	if d.downloadClient != nil {
+		d.downloadClient.SetTransport(pool)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package bilibili
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProxyPool(t *testing.T) {
+	// an HTTP proxy receives the requests of plain HTTP URLs, so the servers
+	// answer them as the target
+	var hits [2]atomic.Int32
+	var servers [2]*httptest.Server
+	for i := range servers {
+		servers[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[i].Add(1)
+			if i == 1 {
+				w.WriteHeader(http.StatusTooManyRequests)
+			}
+		}))
+		defer servers[i].Close()
+	}
+
+	pool, err := newProxyPool([]string{servers[0].URL, " ", servers[1].URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	pool.now = func() time.Time { return now }
+	client := &http.Client{Transport: pool}
+
+	get := func() int {
+		rsp, err := client.Get("http://www.bilibili.com/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = rsp.Body.Close()
+		return rsp.StatusCode
+	}
+	for range 4 {
+		if status := get(); status != http.StatusOK {
+			t.Fatalf("status = %d, want the rejected request sent again through the other proxy", status)
+		}
+	}
+	if hits[0].Load() != 4 || hits[1].Load() != 3 {
+		t.Errorf("hits = %d, %d, want 4, 3 with the failing proxy ejected", hits[0].Load(), hits[1].Load())
+	}
+
+	now = now.Add(proxyEjectDuration)
+	get()
+	if hits[1].Load() != 4 {
+		t.Errorf("failing proxy got %d requests, want it back after %s", hits[1].Load(), proxyEjectDuration)
+	}
+
+	// rejected downloads are neither sent again nor eject the proxy
+	for range 2 * proxyMaxFailures {
+		rsp, err := client.Get("http://upos-sz-mirrorcos.bilivideo.com/video.m4s")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = rsp.Body.Close()
+	}
+	if hits[0].Load() != 8 || hits[1].Load() != 7 {
+		t.Errorf("hits = %d, %d, want 8, 7 with rejected downloads not counted", hits[0].Load(), hits[1].Load())
+	}
+}
+
+func TestNewProxyPoolInvalid(t *testing.T) {
+	for _, proxies := range [][]string{
+		nil,
+		{""},
+		{"localhost:8080"},
+		{"ftp://localhost:8080"},
+	} {
+		_, err := newProxyPool(proxies)
+		if err == nil {
+			t.Errorf("newProxyPool(%q) succeeded", proxies)
+		}
+	}
+}
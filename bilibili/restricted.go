@@ -0,0 +1,41 @@
+package bilibili
+
+import (
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// restrictedRecheckInterval is how long videos found restricted are skipped
+// before they are checked again, restrictions are lifted or bought sometimes.
+const restrictedRecheckInterval = 30 * 24 * time.Hour
+
+// RestrictedVideo is a video the account can't watch, because it's region
+// locked or for members only, kept in the history database.
+type RestrictedVideo struct {
+	Bvid      string `gorm:"primaryKey"`
+	Reason    string
+	CheckedAt time.Time
+}
+
+func (h *History) SaveRestricted(bvid string, reason string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.db.Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(&RestrictedVideo{Bvid: bvid, Reason: reason, CheckedAt: time.Now()}).Error
+}
+
+// IsRestricted checks whether bvid was found restricted after since.
+func (h *History) IsRestricted(bvid string, since time.Time) (bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var video RestrictedVideo
+	err := h.db.Where("bvid = ? AND checked_at >= ?", bvid, since).First(&video).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,37 @@
+package bilibili
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestrictedVideos(t *testing.T) {
+	d := newTestDownloader(t)
+
+	err := d.history.SaveRestricted("BV1y7411Q7Eq", "region locked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := d.history.IsRestricted("BV1y7411Q7Eq", time.Now().Add(time.Hour))
+	if err != nil || ok {
+		t.Errorf("IsRestricted checked before since = %v, %v, want false", ok, err)
+	}
+
+	ok, err = d.IsDownloaded("BV1y7411Q7Eq", 0)
+	if err != nil || !ok {
+		t.Errorf("IsDownloaded of restricted video = %v, %v, want true", ok, err)
+	}
+	if n := d.alreadyDownloaded.Load(); n != 0 {
+		t.Errorf("restricted video counted as already downloaded %d times", n)
+	}
+	ok, err = d.IsDownloaded("BV17x411w7KC", 0)
+	if err != nil || ok {
+		t.Errorf("IsDownloaded of unknown video = %v, %v, want false", ok, err)
+	}
+
+	d.overwrite = true
+	ok, err = d.IsDownloaded("BV1y7411Q7Eq", 0)
+	if err != nil || ok {
+		t.Errorf("IsDownloaded of restricted video with overwrite = %v, %v, want false", ok, err)
+	}
+}
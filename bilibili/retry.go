@@ -0,0 +1,130 @@
+package bilibili
+
+import (
+	"context"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"go.uber.org/zap"
+
+	"github.com/CuteReimu/bilibili/v2"
+)
+
+// https://socialsisteryi.github.io/bilibili-API-collect/docs/misc/errcode.html
+const (
+	codeRiskControl    = -352
+	codeAccessDenied   = -403
+	codeRequestBlocked = -412
+	codeRateLimited    = -509
+)
+
+const defaultMaxRetries = 3
+
+const maxRetryBackoff = 5 * time.Minute
+
+func apiErrorCode(err error) (int, bool) {
+	var e bilibili.Error
+	if errors.As(err, &e) {
+		return e.Code, true
+	}
+	return 0, false
+}
+
+// retryBackoff returns how long to wait before the next attempt, or false if
+// the error is not transient.
+func retryBackoff(err error, attempt int) (time.Duration, bool) {
+	code, ok := apiErrorCode(err)
+	if !ok {
+		return 0, false
+	}
+
+	// -403 is also returned for expired WBI signatures and risk control, so
+	// it's retried instead of taken as the video being restricted
+	var base time.Duration
+	switch code {
+	case codeRiskControl, codeAccessDenied, codeRequestBlocked:
+		base = 2 * time.Second
+	case codeRateLimited:
+		base = 30 * time.Second
+	default:
+		return 0, false
+	}
+	return min(base<<attempt, maxRetryBackoff), true
+}
+
+// retryAPI calls fn until it succeeds, fails with a non-transient error,
+// maxRetries is exhausted or ctx is done.
+func retryAPI[T any](ctx context.Context, maxRetries int, fn func() (T, error)) (T, error) {
+	for attempt := 0; ; attempt++ {
+		v, err := fn()
+		if err == nil || attempt >= maxRetries || ctx.Err() != nil {
+			return v, err
+		}
+
+		backoff, ok := retryBackoff(err, attempt)
+		if !ok {
+			return v, err
+		}
+		zap.L().Warn("API call failed, try again later", zap.Int("attempt", attempt+1),
+			zap.Duration("backoff", backoff), zap.Error(err))
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return v, err
+		case <-timer.C:
+		}
+	}
+}
+
+// retryClientCall is retryAPI for client methods, waiting for the rate limiter
+// before every attempt.
+func retryClientCall[P, T any](ctx context.Context, d *Downloader, fn func(*bilibili.Client, P) (T, error),
+	param P) (T, error) {
+	return retryAPI(ctx, d.maxRetries, func() (T, error) {
+		client, err := d.GetClient(ctx)
+		if err != nil {
+			var zero T
+			return zero, err
+		}
+		return fn(client, param)
+	})
+}
+
+// isPermanentDownloadError reports whether downloading again can't fix err.
+// Merges failed with corrupt streams are retried as the streams are removed
+// and downloaded again.
+func isPermanentDownloadError(err error) bool {
+	if errors.Is(err, ErrMergeFailed) {
+		return !errors.Is(err, ErrFFmpegCorruptInput)
+	}
+	return errors.IsAny(err, ErrFileTooLarge, ErrNotAvailable, ErrNotPublished, ErrInsufficientSpace,
+		ErrNotLoggedIn, context.Canceled, context.DeadlineExceeded)
+}
+
+// retryDownload calls fn until it succeeds, fails with a permanent error or
+// retries are exhausted, waiting interval doubled every retry in between.
+func retryDownload(ctx context.Context, retries int, interval time.Duration, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= retries || isPermanentDownloadError(err) || ctx.Err() != nil {
+			return err
+		}
+
+		backoff := min(interval<<attempt, maxRetryBackoff)
+		zap.L().Warn("Download failed, try again later", zap.Int("attempt", attempt+1),
+			zap.Duration("backoff", backoff), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// downloadWithRetries downloads option with up to d.retries retries.
+func (d *Downloader) downloadWithRetries(ctx context.Context, option DownloadOption) error {
+	return retryDownload(ctx, d.retries, d.downloadRetryInterval, func() error {
+		return d.Download(ctx, option, false, true)
+	})
+}
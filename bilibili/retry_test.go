@@ -0,0 +1,95 @@
+package bilibili
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/CuteReimu/bilibili/v2"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	for _, test := range []struct {
+		err     error
+		attempt int
+		backoff time.Duration
+		ok      bool
+	}{
+		{err: errors.WithStack(bilibili.Error{Code: codeRequestBlocked}), attempt: 0, backoff: 2 * time.Second, ok: true},
+		{err: errors.WithStack(bilibili.Error{Code: codeRiskControl}), attempt: 2, backoff: 8 * time.Second, ok: true},
+		{err: errors.WithStack(bilibili.Error{Code: codeAccessDenied}), attempt: 1, backoff: 4 * time.Second, ok: true},
+		{err: errors.WithStack(bilibili.Error{Code: codeRateLimited}), attempt: 0, backoff: 30 * time.Second, ok: true},
+		{err: errors.WithStack(bilibili.Error{Code: codeRateLimited}), attempt: 10, backoff: maxRetryBackoff, ok: true},
+		{err: errors.WithStack(bilibili.Error{Code: -404}), attempt: 0, ok: false},
+		{err: errors.New("network error"), attempt: 0, ok: false},
+	} {
+		backoff, ok := retryBackoff(test.err, test.attempt)
+		if ok != test.ok || backoff != test.backoff {
+			t.Errorf("retryBackoff(%v, %d) = %v, %v, want %v, %v",
+				test.err, test.attempt, backoff, ok, test.backoff, test.ok)
+		}
+	}
+}
+
+func TestRetryAPINonTransient(t *testing.T) {
+	calls := 0
+	_, err := retryAPI(context.Background(), defaultMaxRetries, func() (int, error) {
+		calls++
+		return 0, errors.WithStack(bilibili.Error{Code: -404})
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+}
+
+func TestRetryAPICanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	done := make(chan error)
+	go func() {
+		_, err := retryAPI(ctx, defaultMaxRetries, func() (int, error) {
+			calls++
+			return 0, errors.WithStack(bilibili.Error{Code: codeRateLimited})
+		})
+		done <- err
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("retryAPI keeps waiting after ctx is canceled")
+	}
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+}
+
+func TestRetryDownload(t *testing.T) {
+	for _, test := range []struct {
+		err   error
+		calls int
+	}{
+		{err: errors.New("stream API failed"), calls: 3},
+		{err: errors.Wrap(ErrFileTooLarge, "file: a.mp4"), calls: 1},
+		{err: errors.Mark(errors.New("ffmpeg failed"), ErrMergeFailed), calls: 1},
+		{err: errors.Mark(errors.WithStack(ErrFFmpegCorruptInput), ErrMergeFailed), calls: 3},
+		{err: nil, calls: 1},
+	} {
+		calls := 0
+		err := retryDownload(context.Background(), 2, 0, func() error {
+			calls++
+			return test.err
+		})
+		if !errors.Is(err, test.err) || calls != test.calls {
+			t.Errorf("retryDownload(%v) = %v after %d calls, want %d calls", test.err, err, calls, test.calls)
+		}
+	}
+}
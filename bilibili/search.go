@@ -3,6 +3,8 @@ package bilibili
 import (
 	"context"
 	"fmt"
+	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -21,28 +23,75 @@ var downloadSearchCmd = &cli.Command{
 	Arguments: []cli.Argument{
 		&cli.StringArg{Name: "keyword", Config: cli.StringConfig{TrimSpace: true}},
 	},
-	Flags: []cli.Flag{
-		&cli.StringFlag{
-			Name:    "config",
-			Aliases: []string{"c"},
-			Value:   "config.yml",
+	Flags: slices.Concat(
+		[]cli.Flag{
+			&cli.IntFlag{
+				Name:    "max-items",
+				Aliases: []string{"max", "m"},
+				Value:   200,
+			},
+			&cli.DurationFlag{
+				Name:  "max-duration",
+				Value: time.Hour,
+			},
+			&cli.DurationFlag{
+				Name:  "min-duration",
+				Usage: "Skip videos shorter than this",
+			},
+			&cli.IntFlag{
+				Name:  "min-views",
+				Usage: "Skip videos played less than this",
+			},
+			&cli.IntFlag{
+				Name:  "min-likes",
+				Usage: "Skip videos liked less than this",
+			},
+			&cli.TimestampFlag{
+				Name:   "after",
+				Usage:  "Skip videos published before this date (YYYY-MM-DD)",
+				Config: cli.TimestampConfig{Layouts: []string{time.DateOnly}},
+			},
+			&cli.TimestampFlag{
+				Name:   "before",
+				Usage:  "Skip videos published after this date (YYYY-MM-DD), videos of the date are kept",
+				Config: cli.TimestampConfig{Layouts: []string{time.DateOnly}},
+			},
+			&cli.Int64Flag{
+				Name:  "max-file-size",
+				Usage: "Maximum size in bytes of a single stream, overrides max_file_size in config",
+			},
+			&cli.IntFlag{
+				Name:  "concurrency",
+				Usage: "Number of videos to download in parallel",
+				Value: 1,
+			},
+			&cli.BoolFlag{
+				Name:    "interactive",
+				Aliases: []string{"i"},
+				Usage:   "Select which of the search results to download",
+			},
+			&cli.BoolFlag{
+				Name:  "resume",
+				Usage: "Resume from the page the last search of the keyword stopped at",
+			},
+			&cli.BoolFlag{
+				Name:  "fresh",
+				Usage: "Forget where the last search of the keyword stopped",
+			},
 		},
-		&cli.IntFlag{
-			Name:    "max-items",
-			Aliases: []string{"max", "m"},
-			Value:   200,
-		},
-		&cli.DurationFlag{
-			Name:  "max-duration",
-			Value: time.Hour,
-		},
-		&cli.Int64Flag{
-			Name:  "max-file-size",
-			Value: 1 << 30,
-		},
-	},
+		DownloadFlags(),
+		batchFlags(),
+		notifyFlags(),
+	),
 	Action: func(ctx context.Context, command *cli.Command) error {
-		maxDuration := command.Duration("max-duration")
+		filter := searchFilter{
+			minDuration: command.Duration("min-duration"),
+			maxDuration: command.Duration("max-duration"),
+			minViews:    command.Int("min-views"),
+			minLikes:    command.Int("min-likes"),
+			after:       command.Timestamp("after"),
+			before:      command.Timestamp("before"),
+		}
 		keyword := command.StringArg("keyword")
 		if keyword == "" {
 			return errors.New("keyword is required")
@@ -52,14 +101,31 @@ var downloadSearchCmd = &cli.Command{
 		if err != nil {
 			return err
 		}
-		d.maxFileSize = command.Int64("max-file-size")
+		if command.IsSet("max-file-size") {
+			d.maxFileSize = command.Int64("max-file-size")
+		}
+
+		if command.Bool("fresh") {
+			err = d.history.ResetSearchPage(keyword)
+			if err != nil {
+				return err
+			}
+		}
+		page := 1
+		if command.Bool("resume") {
+			page, err = d.history.SearchPage(keyword)
+			if err != nil {
+				return err
+			}
+			zap.L().Info("Resume search", zap.String("keyword", keyword), zap.Int("page", page))
+		}
 
 		maxItems := command.Int("max-items")
 		results := make([]*VideoSearchResult, 0)
-		page := 1
+		pages := make(map[string]int)
 
 		for len(results) < maxItems {
-			rsp, err := d.GetClient().IntergratedSearch(bilibili.SearchParam{
+			rsp, err := retryClientCall(ctx, d, (*bilibili.Client).IntergratedSearch, bilibili.SearchParam{
 				Keyword: keyword,
 				Page:    page,
 			})
@@ -71,118 +137,222 @@ var downloadSearchCmd = &cli.Command{
 				break
 			}
 
+			videos := 0
 			for _, result := range rsp.Result {
 				if result.ResultType != "video" {
 					continue
 				}
+				videos += len(result.Data)
 				zap.L().Info("Search", zap.Int("page", page), zap.Int("count", len(result.Data)))
 				for _, m := range result.Data {
 					r := NewVideoSearchResult(m)
+					if r.Bvid == "" {
+						zap.L().Warn("Skip search result without bvid", zap.Any("result", m))
+						continue
+					}
 					if r.IsPay {
 						zap.L().Info("Skip paid video", zap.String("bvid", r.Bvid),
 							zap.String("title", r.Title))
 						continue
 					}
 
-					ok, err := d.history.IsDownloaded(r.Bvid)
+					ok, err := d.IsDownloaded(r.Bvid, 0)
 					if err != nil {
 						return err
 					}
 					if ok {
+						d.alreadyDownloaded.Add(1)
 						continue
 					}
 
-					if maxDuration <= time.Duration(0) {
-						results = append(results, r)
-					} else if r.Duration <= maxDuration {
-						results = append(results, r)
-					} else {
-						zap.L().Info("Skip long video", zap.String("bvid", r.Bvid),
-							zap.String("title", r.Title), zap.Duration("duration", r.Duration))
+					reason := filter.skipReason(r)
+					if reason != "" {
+						zap.L().Info("Skip video", zap.String("reason", reason), zap.String("bvid", r.Bvid),
+							zap.String("title", r.Title), zap.Duration("duration", r.Duration),
+							zap.Time("pubdate", r.PubDate))
+						continue
 					}
+					results = append(results, r)
+					pages[r.Bvid] = page
 				}
 			}
 
+			if videos == 0 || (rsp.NumPages > 0 && page >= rsp.NumPages) {
+				break
+			}
 			page++
 		}
 
 		zap.L().Info("Search completed", zap.Int("results", len(results)))
 
+		options := make([]DownloadOption, 0, len(results))
 		for i, r := range results {
-			err = d.Download(DownloadOption{
+			options = append(options, DownloadOption{
 				Bvid:             r.Bvid,
 				OwnerName:        r.Author,
 				Title:            r.Title,
 				SearchKeyword:    keyword,
 				Tags:             r.Tags,
+				Duration:         r.Duration,
 				DownloadProgress: fmt.Sprintf("(%d/%d)", i+1, len(results)),
-			}, false, true)
+			})
+		}
+
+		if command.Bool("dry-run") {
+			return printCandidates(options)
+		}
+		if command.Bool("interactive") {
+			options, err = selectCandidates(os.Stdin, os.Stdout, options)
 			if err != nil {
-				zap.L().Error("Download failed", zap.String("bvid", r.Bvid), zap.Error(err))
-				continue
+				return err
 			}
 		}
-
-		return nil
+		summary := d.DownloadBatch(ctx, options, command.Int("concurrency"))
+		err = d.history.SaveSearchPage(keyword, resumePage(options, pages, summary, page))
+		if err != nil {
+			zap.L().Error("Save search state failed", zap.String("keyword", keyword), zap.Error(err))
+		}
+		return d.finishBatch(command, summary)
 	},
 }
 
+type searchFilter struct {
+	minDuration time.Duration
+	maxDuration time.Duration
+	minViews    int
+	minLikes    int
+	after       time.Time
+	before      time.Time
+}
+
+// skipReason returns why r is filtered out, or empty if it should be downloaded.
+func (f *searchFilter) skipReason(r *VideoSearchResult) string {
+	if f.maxDuration > 0 && r.Duration > f.maxDuration {
+		return "too long"
+	}
+	if f.minDuration > 0 && r.Duration < f.minDuration {
+		return "too short"
+	}
+	if r.PlayCount < f.minViews {
+		return "too few views"
+	}
+	if r.LikeCount < f.minLikes {
+		return "too few likes"
+	}
+	if !f.after.IsZero() && r.PubDate.Before(f.after) {
+		return "published too early"
+	}
+	// before is the start of the last day kept
+	if !f.before.IsZero() && !r.PubDate.Before(f.before.AddDate(0, 0, 1)) {
+		return "published too late"
+	}
+	return ""
+}
+
 type VideoSearchResult struct {
 	Bvid     string        `json:"bvid"`
 	Author   string        `json:"author"`
 	Title    string        `json:"title"`
 	Tags     []string      `json:"tags"`
 	Duration time.Duration `json:"duration"`
+	PubDate  time.Time     `json:"pubdate"`
 	IsPay    bool          `json:"is_pay"`
+
+	PlayCount     int `json:"play_count"`
+	DanmakuCount  int `json:"danmaku_count"`
+	LikeCount     int `json:"like_count"`
+	FavoriteCount int `json:"favorite_count"`
 }
 
-func parseDuration(s string) time.Duration {
-	var err error
-	d := time.Duration(0)
+func parseDuration(s string) (time.Duration, error) {
 	parts := strings.Split(s, ":")
-	var h, m, sec int64
-	if len(parts) == 2 {
-		m, err = strconv.ParseInt(parts[0], 10, 64)
-		if err != nil {
-			panic(err)
-		}
-		sec, err = strconv.ParseInt(parts[1], 10, 64)
-		if err != nil {
-			panic(err)
-		}
-	} else if len(parts) == 3 {
-		h, err = strconv.ParseInt(parts[0], 10, 64)
-		if err != nil {
-			panic(err)
-		}
-		m, err = strconv.ParseInt(parts[1], 10, 64)
-		if err != nil {
-			panic(err)
-		}
-		sec, err = strconv.ParseInt(parts[2], 10, 64)
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, errors.Newf("invalid duration: %s", s)
+	}
+
+	d := time.Duration(0)
+	for _, part := range parts {
+		v, err := strconv.ParseInt(part, 10, 64)
 		if err != nil {
-			panic(err)
+			return 0, errors.Wrapf(err, "invalid duration: %s", s)
 		}
-	} else {
-		panic(errors.Newf("invalid duration: %s", s))
+		d = d*60 + time.Duration(v)
 	}
+	return d * time.Second, nil
+}
 
-	d += time.Duration(h) * time.Hour
-	d += time.Duration(m) * time.Minute
-	d += time.Duration(sec) * time.Second
-	return d
+func getString(m map[string]any, key string) string {
+	s, _ := m[key].(string)
+	return s
 }
 
+func getFloat(m map[string]any, key string) float64 {
+	switch v := m[key].(type) {
+	case float64:
+		return v
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	}
+	return 0
+}
+
+// NewVideoSearchResult converts a search result item, missing or mistyped
+// fields are left as zero values.
 func NewVideoSearchResult(m map[string]any) *VideoSearchResult {
-	durationStr := m["duration"].(string)
+	bvid := getString(m, "bvid")
+	duration, err := parseDuration(getString(m, "duration"))
+	if err != nil {
+		zap.L().Warn("Parse search result failed", zap.String("bvid", bvid), zap.Error(err))
+	}
+
+	var tags []string
+	if tag := getString(m, "tag"); tag != "" {
+		for _, t := range strings.Split(tag, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				tags = append(tags, t)
+			}
+		}
+	}
+
 	return &VideoSearchResult{
-		Bvid:     m["bvid"].(string),
-		Author:   m["author"].(string),
-		Title:    getInnerText(m["title"].(string)),
-		Tags:     strings.Split(m["tag"].(string), ","),
-		Duration: parseDuration(durationStr),
-		IsPay:    m["is_pay"].(float64) != 0,
+		Bvid:     bvid,
+		Author:   getString(m, "author"),
+		Title:    getInnerText(getString(m, "title")),
+		Tags:     tags,
+		Duration: duration,
+		PubDate:  time.Unix(int64(getFloat(m, "pubdate")), 0),
+		IsPay:    getFloat(m, "is_pay") != 0,
+
+		PlayCount:     parseCount(m["play"]),
+		DanmakuCount:  parseCount(m["video_review"]),
+		LikeCount:     parseCount(m["like"]),
+		FavoriteCount: parseCount(m["favorites"]),
+	}
+}
+
+// parseCount parses a count which is either a number or a string like
+// "1.5万", unparsable values count as 0.
+func parseCount(v any) int {
+	switch v := v.(type) {
+	case float64:
+		return int(v)
+	case string:
+		multiplier := 1.0
+		if s, ok := strings.CutSuffix(v, "万"); ok {
+			v = s
+			multiplier = 10000
+		} else if s, ok := strings.CutSuffix(v, "亿"); ok {
+			v = s
+			multiplier = 100000000
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return 0
+		}
+		return int(f * multiplier)
 	}
+	return 0
 }
 
 func getInnerText(s string) string {
@@ -0,0 +1,63 @@
+package bilibili
+
+import (
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SearchState is where search of a keyword resumes, kept in the history
+// database.
+type SearchState struct {
+	Keyword   string `gorm:"primaryKey"`
+	Page      int
+	UpdatedAt time.Time
+}
+
+// SearchPage returns the page search of keyword resumes from, 1 if there's
+// no state.
+func (h *History) SearchPage(keyword string) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var state SearchState
+	err := h.db.Where("keyword = ?", keyword).First(&state).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && state.Page < 1) {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return state.Page, nil
+}
+
+func (h *History) SaveSearchPage(keyword string, page int) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.db.Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(&SearchState{Keyword: keyword, Page: page}).Error
+}
+
+func (h *History) ResetSearchPage(keyword string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.db.Where("keyword = ?", keyword).Delete(&SearchState{}).Error
+}
+
+// resumePage returns the page the next run resumes from: the page of the
+// first option the batch didn't start, or lastPage if it started all of them.
+// The page is searched again then, downloaded videos are skipped by history.
+func resumePage(options []DownloadOption, pages map[string]int, summary *BatchSummary, lastPage int) int {
+	if summary.NotStarted == 0 || summary.NotStarted > len(options) {
+		return lastPage
+	}
+	page := lastPage
+	for _, option := range options[len(options)-summary.NotStarted:] {
+		if p, ok := pages[option.Bvid]; ok {
+			page = min(page, p)
+		}
+	}
+	return page
+}
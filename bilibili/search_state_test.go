@@ -0,0 +1,50 @@
+package bilibili
+
+import "testing"
+
+func TestSearchState(t *testing.T) {
+	h := newTestHistory(t)
+	page, err := h.SearchPage("k")
+	if err != nil || page != 1 {
+		t.Fatalf("SearchPage without state = %d, %v, want 1", page, err)
+	}
+
+	for _, want := range []int{5, 3} {
+		err = h.SaveSearchPage("k", want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, err = h.SearchPage("k")
+		if err != nil || page != want {
+			t.Fatalf("SearchPage = %d, %v, want %d", page, err, want)
+		}
+	}
+
+	err = h.ResetSearchPage("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err = h.SearchPage("k")
+	if err != nil || page != 1 {
+		t.Fatalf("SearchPage after reset = %d, %v, want 1", page, err)
+	}
+}
+
+func TestResumePage(t *testing.T) {
+	options := []DownloadOption{{Bvid: "a"}, {Bvid: "b"}, {Bvid: "c"}, {Bvid: "d"}}
+	pages := map[string]int{"a": 2, "b": 3, "c": 3, "d": 4}
+	for _, test := range []struct {
+		skipped int
+		want    int
+	}{
+		{skipped: 0, want: 5},
+		{skipped: 1, want: 4},
+		{skipped: 2, want: 3},
+		{skipped: 4, want: 2},
+	} {
+		got := resumePage(options, pages, &BatchSummary{NotStarted: test.skipped}, 5)
+		if got != test.want {
+			t.Errorf("resumePage with %d skipped = %d, want %d", test.skipped, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package bilibili
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewVideoSearchResult(t *testing.T) {
+	r := NewVideoSearchResult(map[string]any{
+		"bvid":     "BV1y7411Q7Eq",
+		"author":   "author",
+		"title":    `<em class="keyword">大黑塔</em>`,
+		"tag":      "a,b",
+		"duration": "1:02:03",
+		"pubdate":  float64(1700000000),
+		"is_pay":   float64(0),
+	})
+	if r.Title != "大黑塔" {
+		t.Errorf("Title = %q", r.Title)
+	}
+	if r.Duration != time.Hour+2*time.Minute+3*time.Second {
+		t.Errorf("Duration = %v", r.Duration)
+	}
+	if !r.PubDate.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("PubDate = %v", r.PubDate)
+	}
+}
+
+func TestSearchFilter(t *testing.T) {
+	filter := searchFilter{
+		minDuration: time.Minute,
+		maxDuration: time.Hour,
+		after:       time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local),
+		before:      time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local),
+		minViews:    100,
+	}
+	pubDate := time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local)
+	for _, test := range []struct {
+		r    VideoSearchResult
+		skip bool
+	}{
+		{r: VideoSearchResult{Duration: 10 * time.Minute, PubDate: pubDate, PlayCount: 100}, skip: false},
+		{r: VideoSearchResult{Duration: 10 * time.Second, PubDate: pubDate, PlayCount: 100}, skip: true},
+		{r: VideoSearchResult{Duration: 2 * time.Hour, PubDate: pubDate, PlayCount: 100}, skip: true},
+		{r: VideoSearchResult{Duration: 10 * time.Minute, PubDate: filter.after.Add(-time.Second), PlayCount: 100}, skip: true},
+		{r: VideoSearchResult{Duration: 10 * time.Minute, PubDate: filter.before.Add(23 * time.Hour), PlayCount: 100}, skip: false},
+		{r: VideoSearchResult{Duration: 10 * time.Minute, PubDate: filter.before.AddDate(0, 0, 1), PlayCount: 100}, skip: true},
+		{r: VideoSearchResult{Duration: 10 * time.Minute, PubDate: pubDate, PlayCount: 10}, skip: true},
+	} {
+		reason := filter.skipReason(&test.r)
+		if (reason != "") != test.skip {
+			t.Errorf("skipReason(%+v) = %q, want skip %v", test.r, reason, test.skip)
+		}
+	}
+}
+
+func TestParseCount(t *testing.T) {
+	for _, test := range []struct {
+		v    any
+		want int
+	}{
+		{v: float64(1234), want: 1234},
+		{v: "1234", want: 1234},
+		{v: "1.5万", want: 15000},
+		{v: "2亿", want: 200000000},
+		{v: "--", want: 0},
+		{v: nil, want: 0},
+	} {
+		got := parseCount(test.v)
+		if got != test.want {
+			t.Errorf("parseCount(%v) = %d, want %d", test.v, got, test.want)
+		}
+	}
+}
+
+func TestNewVideoSearchResultMalformed(t *testing.T) {
+	r := NewVideoSearchResult(map[string]any{
+		"bvid":     "BV1y7411Q7Eq",
+		"title":    float64(1),
+		"duration": "bad",
+		"is_pay":   "1",
+	})
+	if r.Bvid != "BV1y7411Q7Eq" || r.Title != "" || r.Duration != 0 || !r.IsPay {
+		t.Errorf("unexpected result: %+v", r)
+	}
+
+	r = NewVideoSearchResult(map[string]any{})
+	if r.Bvid != "" || r.Tags != nil {
+		t.Errorf("unexpected result: %+v", r)
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	for _, test := range []struct {
+		s    string
+		want time.Duration
+		ok   bool
+	}{
+		{s: "05:30", want: 5*time.Minute + 30*time.Second, ok: true},
+		{s: "1:00:01", want: time.Hour + time.Second, ok: true},
+		{s: "", ok: false},
+		{s: "1:2:3:4", ok: false},
+		{s: "a:b", ok: false},
+	} {
+		got, err := parseDuration(test.s)
+		if (err == nil) != test.ok || got != test.want {
+			t.Errorf("parseDuration(%q) = %v, %v", test.s, got, err)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package bilibili
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/urfave/cli/v3"
+	"go.uber.org/zap"
+)
+
+const seasonArchivesPageSize = 30
+
+// SeasonArchive is a video of a season (合集) of a creator.
+type SeasonArchive struct {
+	Aid      int    `json:"aid"`
+	Bvid     string `json:"bvid"`
+	Title    string `json:"title"`
+	Duration int    `json:"duration"`
+	Pubdate  int64  `json:"pubdate"`
+}
+
+type SeasonArchives struct {
+	Archives []SeasonArchive `json:"archives"`
+	Meta     struct {
+		Name     string `json:"name"`
+		Mid      int    `json:"mid"`
+		SeasonId int    `json:"season_id"`
+		Total    int    `json:"total"`
+	} `json:"meta"`
+	Page struct {
+		PageNum  int `json:"page_num"`
+		PageSize int `json:"page_size"`
+		Total    int `json:"total"`
+	} `json:"page"`
+}
+
+func (d *Downloader) GetSeasonArchives(ctx context.Context, mid int, seasonId int, page int) (*SeasonArchives, error) {
+	return retryAPI(ctx, d.maxRetries, func() (*SeasonArchives, error) {
+		client, err := d.GetClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return getResponseData[SeasonArchives](client, "https://api.bilibili.com/x/polymer/web-space/seasons_archives_list", map[string]string{
+			"mid":          strconv.Itoa(mid),
+			"season_id":    strconv.Itoa(seasonId),
+			"page_num":     strconv.Itoa(page),
+			"page_size":    strconv.Itoa(seasonArchivesPageSize),
+			"sort_reverse": "false",
+		})
+	})
+}
+
+// collectSeasonArchives returns the name of a season and all of its videos in
+// order, fetch returns a page of them starting from 1.
+func collectSeasonArchives(fetch func(page int) (*SeasonArchives, error)) (string, []SeasonArchive, error) {
+	name := ""
+	archives := make([]SeasonArchive, 0)
+	for page := 1; ; page++ {
+		rsp, err := fetch(page)
+		if err != nil {
+			return "", nil, err
+		}
+		name = rsp.Meta.Name
+		archives = append(archives, rsp.Archives...)
+		zap.L().Info("Season", zap.String("name", name), zap.Int("page", page),
+			zap.Int("count", len(rsp.Archives)), zap.Int("total", rsp.Page.Total))
+		if len(rsp.Archives) == 0 || len(archives) >= rsp.Page.Total {
+			return name, archives, nil
+		}
+	}
+}
+
+// seasonEpisodeTitle numbers the title of the index-th video of a season of
+// total videos, zero padded so that file names sort in order.
+func seasonEpisodeTitle(index int, total int, title string) string {
+	return fmt.Sprintf("%0*d %s", len(strconv.Itoa(total)), index, title)
+}
+
+var downloadSeasonCmd = &cli.Command{
+	Name:  "season",
+	Usage: "Download videos of a season (合集) of a creator in order",
+	Flags: slices.Concat(
+		[]cli.Flag{
+			&cli.IntFlag{
+				Name:     "mid",
+				Usage:    "User ID of the creator of the season",
+				Required: true,
+			},
+			&cli.IntFlag{
+				Name:     "sid",
+				Usage:    "Season ID, the number after sid= in the URL of the season",
+				Required: true,
+			},
+			&cli.IntFlag{
+				Name:  "concurrency",
+				Usage: "Number of videos to download in parallel",
+				Value: 1,
+			},
+		},
+		DownloadFlags(),
+		batchFlags(),
+		notifyFlags(),
+	),
+	Action: func(ctx context.Context, command *cli.Command) error {
+		mid := command.Int("mid")
+		seasonId := command.Int("sid")
+		d, err := downloaderFromCliCommand(command)
+		if err != nil {
+			return err
+		}
+
+		name, archives, err := collectSeasonArchives(func(page int) (*SeasonArchives, error) {
+			return d.GetSeasonArchives(ctx, mid, seasonId, page)
+		})
+		if err != nil {
+			return err
+		}
+		if len(archives) == 0 {
+			zap.L().Info("Season is empty", zap.String("name", name))
+			return nil
+		}
+
+		// archives don't have the name of the creator, the info is cached
+		// for the downloads
+		info, err := d.GetVideoInfo(ctx, archives[0].Bvid)
+		if err != nil {
+			return err
+		}
+		ownerName := info.Owner.Name
+
+		options := make([]DownloadOption, 0, len(archives))
+		for i, a := range archives {
+			ok, err := d.IsDownloaded(a.Bvid, 0)
+			if err != nil {
+				return err
+			}
+			if ok {
+				d.alreadyDownloaded.Add(1)
+				continue
+			}
+			options = append(options, DownloadOption{
+				Bvid:      a.Bvid,
+				OwnerName: ownerName,
+				Title:     seasonEpisodeTitle(i+1, len(archives), a.Title),
+				Folder:    name,
+				Duration:  time.Duration(a.Duration) * time.Second,
+			})
+		}
+		zap.L().Info("Season completed", zap.String("name", name), zap.Int("videos", len(archives)),
+			zap.Int("results", len(options)))
+		for i := range options {
+			options[i].DownloadProgress = fmt.Sprintf("(%d/%d)", i+1, len(options))
+		}
+
+		if command.Bool("dry-run") {
+			return printCandidates(options)
+		}
+		return d.finishBatch(command, d.DownloadBatch(ctx, options, command.Int("concurrency")))
+	},
+}
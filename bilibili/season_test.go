@@ -0,0 +1,51 @@
+package bilibili
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCollectSeasonArchives(t *testing.T) {
+	const total = 5
+	fetched := 0
+	name, archives, err := collectSeasonArchives(func(page int) (*SeasonArchives, error) {
+		fetched++
+		rsp := &SeasonArchives{}
+		rsp.Meta.Name = "season"
+		rsp.Page.PageNum = page
+		rsp.Page.PageSize = 2
+		rsp.Page.Total = total
+		for i := (page - 1) * 2; i < min(page*2, total); i++ {
+			rsp.Archives = append(rsp.Archives, SeasonArchive{Bvid: fmt.Sprintf("BV%d", i)})
+		}
+		return rsp, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "season" || len(archives) != total || fetched != 3 {
+		t.Fatalf("collectSeasonArchives() = %q, %d archives after %d pages, want 5 after 3", name, len(archives), fetched)
+	}
+	for i, a := range archives {
+		if want := fmt.Sprintf("BV%d", i); a.Bvid != want {
+			t.Errorf("archive %d = %s, want %s", i, a.Bvid, want)
+		}
+	}
+}
+
+func TestSeasonEpisodeTitle(t *testing.T) {
+	for _, test := range []struct {
+		index int
+		total int
+		want  string
+	}{
+		{index: 1, total: 9, want: "1 t"},
+		{index: 3, total: 12, want: "03 t"},
+		{index: 100, total: 100, want: "100 t"},
+	} {
+		got := seasonEpisodeTitle(test.index, test.total, "t")
+		if got != test.want {
+			t.Errorf("seasonEpisodeTitle(%d, %d) = %q, want %q", test.index, test.total, got, test.want)
+		}
+	}
+}
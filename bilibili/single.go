@@ -2,56 +2,130 @@ package bilibili
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"slices"
+	"time"
 
 	"github.com/cockroachdb/errors"
 	"github.com/urfave/cli/v3"
+
+	"github.com/CuteReimu/bilibili/v2"
 )
 
 var downloadSingleCmd = &cli.Command{
 	Name:  "single",
-	Usage: "Download a single video by BVID/AID",
-	Flags: []cli.Flag{
-		&cli.StringFlag{Name: "bvid"}, &cli.IntFlag{Name: "aid"},
-		&cli.StringFlag{
-			Name:    "config",
-			Aliases: []string{"c"},
-			Value:   "config.yml",
-		},
-		&cli.StringFlag{
-			Name:    "output",
-			Aliases: []string{"o"},
-			Value:   "./output",
-		},
-		&cli.StringFlag{
-			Name:  "ffmpeg",
-			Value: "ffmpeg" + defaultExecutableFileExtension(),
+	Usage: "Download a single video by BVID/AID/URL",
+	Flags: slices.Concat(
+		[]cli.Flag{
+			&cli.StringFlag{Name: "bvid"}, &cli.IntFlag{Name: "aid"},
+			&cli.StringFlag{
+				Name:  "url",
+				Usage: "Video URL or b23.tv share link",
+			},
+			&cli.BoolFlag{
+				Name:  "list-formats",
+				Usage: "Print the available streams without downloading",
+			},
+			&cli.StringFlag{
+				Name:  "output-name",
+				Usage: "Base name of the output file instead of the output template, the extension is appended",
+			},
 		},
-	},
+		DownloadFlags(),
+	),
 	Action: func(ctx context.Context, command *cli.Command) error {
+		if rawURL := command.String("url"); rawURL != "" {
+			return DownloadVideoURL(ctx, command, rawURL)
+		}
+
 		bvid := command.String("bvid")
 		aid := command.Int("aid")
 		if bvid == "" && aid == 0 {
-			return errors.New("bvid/aid is required")
+			return errors.New("bvid/aid/url is required")
 		}
 		if aid != 0 {
 			bvid = convertAidToBvid(aid)
 		}
+		return downloadSingle(ctx, command, bvid, 0)
+	},
+}
 
-		d, err := downloaderFromCliCommand(command)
+// downloadSingle downloads a video, page selects a part of multi-part videos
+// and 0 means the first one.
+func downloadSingle(ctx context.Context, command *cli.Command, bvid string, page int) error {
+	outputName := command.String("output-name")
+	if outputName != "" {
+		err := validateOutputName(outputName)
 		if err != nil {
 			return err
 		}
+	}
+
+	d, err := downloaderFromCliCommand(command)
+	if err != nil {
+		return err
+	}
+
+	option, err := d.videoOption(ctx, bvid, page)
+	if err != nil {
+		return err
+	}
+	option.OutputName = outputName
 
-		videoInfo, err := d.GetVideoInfo(bvid)
+	if command.Bool("list-formats") {
+		result, err := d.getVideoStream(ctx, *option)
 		if err != nil {
-			return err
+			return errors.Wrapf(wrapNotAvailable(err), "get video stream, bvid: %s", option.Bvid)
 		}
+		return printFormats(os.Stdout, result)
+	}
 
-		return d.Download(DownloadOption{
-			Bvid:      videoInfo.Bvid,
-			Cid:       videoInfo.Cid,
-			OwnerName: videoInfo.Owner.Name,
-			Title:     videoInfo.Title,
-		}, false, true)
-	},
+	err = d.downloadWithRetries(ctx, *option)
+	if err != nil {
+		return err
+	}
+	return d.SaveConfig()
+}
+
+// videoOption looks up the download option of page of a video, 0 means the
+// first page.
+func (d *Downloader) videoOption(ctx context.Context, bvid string, page int) (*DownloadOption, error) {
+	videoInfo, err := d.GetVideoInfo(ctx, bvid)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkPublished(videoInfo, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	option := &DownloadOption{
+		Bvid:        videoInfo.Bvid,
+		Cid:         videoInfo.Cid,
+		OwnerName:   videoInfo.Owner.Name,
+		Title:       videoInfo.Title,
+		Interactive: videoInfo.Rights.IsSteinGate != 0,
+	}
+	if page > 0 {
+		i := slices.IndexFunc(videoInfo.Pages, func(p bilibili.VideoPage) bool { return p.Page == page })
+		if i < 0 {
+			return nil, errors.Newf("page %d not found, bvid: %s", page, bvid)
+		}
+		option.Cid = videoInfo.Pages[i].Cid
+		if len(videoInfo.Pages) > 1 {
+			option.Title = fmt.Sprintf("%s - P%d %s", videoInfo.Title, page, videoInfo.Pages[i].Part)
+		}
+	}
+	return option, nil
+}
+
+// DownloadVideoURL downloads the video of a bilibili.com or b23.tv URL.
+func DownloadVideoURL(ctx context.Context, command *cli.Command, rawURL string) error {
+	v, err := ResolveVideoURL(rawURL)
+	if err != nil {
+		return err
+	}
+	return downloadSingle(ctx, command, v.Bvid, v.Page)
 }
@@ -0,0 +1,145 @@
+package bilibili
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/urfave/cli/v3"
+
+	"github.com/CuteReimu/bilibili/v2"
+	"github.com/fanyang89/media-collector/source"
+)
+
+// Source is the bilibili implementation of source.Source, IDs are BVIDs,
+// AIDs like av170001 or video URLs.
+type Source struct {
+	d *Downloader
+}
+
+var _ source.Source = (*Source)(nil)
+
+// NewSourceFromCliCommand creates a source with the downloader configured by
+// the flags of command.
+func NewSourceFromCliCommand(command *cli.Command) (source.Source, error) {
+	d, err := downloaderFromCliCommand(command)
+	if err != nil {
+		return nil, err
+	}
+	return &Source{d: d}, nil
+}
+
+func (s *Source) Name() string {
+	return "bilibili"
+}
+
+func resolveVideoID(id string) (*VideoURL, error) {
+	if bvidPattern.MatchString(id) {
+		return &VideoURL{Bvid: id}, nil
+	}
+	if m := aidPattern.FindStringSubmatch(id); m != nil {
+		aid, err := strconv.Atoi(m[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid aid: %s", id)
+		}
+		return &VideoURL{Bvid: convertAidToBvid(aid)}, nil
+	}
+	return ResolveVideoURL(id)
+}
+
+func (s *Source) Resolve(ctx context.Context, id string) (*source.StreamInfo, error) {
+	v, err := resolveVideoID(id)
+	if err != nil {
+		return nil, err
+	}
+	option, err := s.d.videoOption(ctx, v.Bvid, v.Page)
+	if err != nil {
+		return nil, err
+	}
+	result, err := s.d.getVideoStream(ctx, *option)
+	if err != nil {
+		return nil, errors.Wrapf(wrapNotAvailable(err), "get video stream, bvid: %s", option.Bvid)
+	}
+
+	info := &source.StreamInfo{
+		Item: source.Item{
+			ID:     option.Bvid,
+			Title:  option.Title,
+			Author: option.OwnerName,
+		},
+	}
+	if len(result.Dash.Video) > 0 && len(result.Dash.Audio) > 0 {
+		sortByBandwidth(result.Dash.Video)
+		sortByBandwidth(result.Dash.Audio)
+		video, _ := selectVideoStream(result.Dash.Video, s.d.codec)
+		audio, _ := selectAudioStream(&result.Dash, s.d.audioQuality)
+		info.Duration = time.Duration(result.Dash.Duration) * time.Second
+		info.Streams = []source.Stream{
+			{Kind: source.StreamVideo, Format: video.Codecs, URLs: append([]string{video.BaseUrl}, video.BackupUrl...)},
+			{Kind: source.StreamAudio, Format: audio.Codecs, URLs: append([]string{audio.BaseUrl}, audio.BackupUrl...)},
+		}
+		return info, nil
+	}
+	for _, durl := range result.Durl {
+		info.Streams = append(info.Streams, source.Stream{
+			Kind:   source.StreamMuxed,
+			Format: result.Format,
+			URLs:   append([]string{durl.Url}, durl.BackupUrl...),
+		})
+	}
+	if len(info.Streams) == 0 {
+		return nil, errors.Wrapf(ErrNotAvailable, "no playable stream, bvid: %s", option.Bvid)
+	}
+	return info, nil
+}
+
+// Search returns the videos on the first page of search results.
+func (s *Source) Search(ctx context.Context, query string) ([]source.Item, error) {
+	rsp, err := retryClientCall(ctx, s.d, (*bilibili.Client).IntergratedSearch, bilibili.SearchParam{
+		Keyword: query,
+		Page:    1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rsp == nil {
+		return nil, nil
+	}
+
+	items := make([]source.Item, 0)
+	for _, result := range rsp.Result {
+		if result.ResultType != "video" {
+			continue
+		}
+		for _, m := range result.Data {
+			r := NewVideoSearchResult(m)
+			if r.Bvid == "" {
+				continue
+			}
+			items = append(items, source.Item{
+				ID:       r.Bvid,
+				Title:    r.Title,
+				Author:   r.Author,
+				Duration: r.Duration,
+			})
+		}
+	}
+	return items, nil
+}
+
+func (s *Source) Download(ctx context.Context, id string) error {
+	v, err := resolveVideoID(id)
+	if err != nil {
+		return err
+	}
+	option, err := s.d.videoOption(ctx, v.Bvid, v.Page)
+	if err != nil {
+		return err
+	}
+	err = s.d.Download(ctx, *option, false, true)
+	if err != nil {
+		return err
+	}
+	return s.d.SaveConfig()
+}
@@ -0,0 +1,189 @@
+package bilibili
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/urfave/cli/v3"
+	"go.uber.org/zap"
+)
+
+const spaceVideosPageSize = 50
+
+var spaceVideoOrders = map[string]string{
+	"newest":      "pubdate",
+	"most-played": "click",
+}
+
+type SpaceVideo struct {
+	Aid     int    `json:"aid"`
+	Bvid    string `json:"bvid"`
+	Title   string `json:"title"`
+	Author  string `json:"author"`
+	Mid     int    `json:"mid"`
+	Length  string `json:"length"`
+	Created int64  `json:"created"`
+	IsPay   int    `json:"is_pay"`
+}
+
+type SpaceVideos struct {
+	List struct {
+		Vlist []SpaceVideo `json:"vlist"`
+	} `json:"list"`
+	Page struct {
+		Pn    int `json:"pn"`
+		Ps    int `json:"ps"`
+		Count int `json:"count"`
+	} `json:"page"`
+}
+
+func (d *Downloader) GetSpaceVideos(ctx context.Context, mid int, order string, page int) (*SpaceVideos, error) {
+	return retryAPI(ctx, d.maxRetries, func() (*SpaceVideos, error) {
+		client, err := d.GetClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return getResponseData[SpaceVideos](client, "https://api.bilibili.com/x/space/wbi/arc/search", map[string]string{
+			"mid":   strconv.Itoa(mid),
+			"order": order,
+			"pn":    strconv.Itoa(page),
+			"ps":    strconv.Itoa(spaceVideosPageSize),
+		})
+	})
+}
+
+// collectSpaceVideos returns up to maxItems videos of mid not downloaded yet,
+// published after since. Videos keep rejects are skipped, nil keeps all.
+func (d *Downloader) collectSpaceVideos(ctx context.Context, mid int, order string, maxItems int, since time.Time,
+	keep func(v *SpaceVideo) bool) ([]SpaceVideo, error) {
+	results := make([]SpaceVideo, 0)
+	reachedSince := false
+
+	for page := 1; len(results) < maxItems && !reachedSince; page++ {
+		rsp, err := d.GetSpaceVideos(ctx, mid, order, page)
+		if err != nil {
+			return nil, err
+		}
+		if len(rsp.List.Vlist) == 0 {
+			break
+		}
+		zap.L().Info("Space", zap.Int("mid", mid), zap.Int("page", page), zap.Int("count", len(rsp.List.Vlist)))
+
+		for _, v := range rsp.List.Vlist {
+			if len(results) >= maxItems {
+				break
+			}
+			if time.Unix(v.Created, 0).Before(since) {
+				// the rest are older when sorted by publish time
+				if order == spaceVideoOrders["newest"] {
+					reachedSince = true
+					break
+				}
+				continue
+			}
+			if v.IsPay != 0 {
+				zap.L().Info("Skip paid video", zap.String("bvid", v.Bvid),
+					zap.String("title", v.Title))
+				continue
+			}
+			if keep != nil && !keep(&v) {
+				continue
+			}
+
+			ok, err := d.IsDownloaded(v.Bvid, 0)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				d.alreadyDownloaded.Add(1)
+				continue
+			}
+			results = append(results, v)
+		}
+
+		if page*rsp.Page.Ps >= rsp.Page.Count {
+			break
+		}
+	}
+
+	zap.L().Info("Space completed", zap.Int("mid", mid), zap.Int("results", len(results)))
+	return results, nil
+}
+
+func spaceVideoOptions(results []SpaceVideo) []DownloadOption {
+	options := make([]DownloadOption, 0, len(results))
+	for i, v := range results {
+		duration, _ := parseDuration(v.Length)
+		options = append(options, DownloadOption{
+			Bvid:             v.Bvid,
+			OwnerName:        v.Author,
+			Title:            v.Title,
+			Duration:         duration,
+			DownloadProgress: fmt.Sprintf("(%d/%d)", i+1, len(results)),
+		})
+	}
+	return options
+}
+
+var downloadSpaceCmd = &cli.Command{
+	Name:  "space",
+	Usage: "Download videos uploaded by a user",
+	Flags: slices.Concat(
+		[]cli.Flag{
+			&cli.IntFlag{
+				Name:     "mid",
+				Usage:    "User ID",
+				Required: true,
+			},
+			&cli.IntFlag{
+				Name:    "max-items",
+				Aliases: []string{"max", "m"},
+				Value:   200,
+			},
+			&cli.StringFlag{
+				Name:  "order",
+				Usage: "Order of videos, newest or most-played",
+				Value: "newest",
+			},
+			&cli.IntFlag{
+				Name:  "concurrency",
+				Usage: "Number of videos to download in parallel",
+				Value: 1,
+			},
+			&cli.DurationFlag{
+				Name:  "since",
+				Usage: "Only download videos published within this duration, e.g. 168h, 0 means all",
+			},
+		},
+		DownloadFlags(),
+		batchFlags(),
+		notifyFlags(),
+	),
+	Action: func(ctx context.Context, command *cli.Command) error {
+		mid := command.Int("mid")
+		order, ok := spaceVideoOrders[command.String("order")]
+		if !ok {
+			return errors.Newf("invalid order: %s", command.String("order"))
+		}
+
+		d, err := downloaderFromCliCommand(command)
+		if err != nil {
+			return err
+		}
+
+		results, err := d.collectSpaceVideos(ctx, mid, order, command.Int("max-items"), sinceCutoff(command), nil)
+		if err != nil {
+			return err
+		}
+		options := spaceVideoOptions(results)
+
+		if command.Bool("dry-run") {
+			return printCandidates(options)
+		}
+		return d.finishBatch(command, d.DownloadBatch(ctx, options, command.Int("concurrency")))
+	},
+}
@@ -0,0 +1,112 @@
+package bilibili
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// shortLinkHosts are the hosts of share links, which redirect to the video.
+var shortLinkHosts = []string{"b23.tv", "www.b23.tv", "bili2233.cn"}
+
+const maxShortLinkRedirects = 10
+
+var (
+	bvidPattern = regexp.MustCompile(`^BV1[0-9A-Za-z]{9}$`)
+	aidPattern  = regexp.MustCompile(`^av([0-9]+)$`)
+)
+
+// VideoURL is a video parsed from a URL, Page is the 1-based part number
+// from the p query param, 0 if not given.
+type VideoURL struct {
+	Bvid string
+	Page int
+}
+
+// ResolveVideoURL parses a video URL, short links of shortLinkHosts are
+// resolved first.
+func ResolveVideoURL(rawURL string) (*VideoURL, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid url: %s", rawURL)
+	}
+	if isShortLink(u) {
+		return resolveShortLink(u.String())
+	}
+	return parseVideoURL(u)
+}
+
+// isShortLink reports whether u is a share link.
+func isShortLink(u *url.URL) bool {
+	return slices.Contains(shortLinkHosts, strings.ToLower(u.Hostname()))
+}
+
+// resolveShortLink follows the redirects of a share link hop by hop and
+// returns the video it points to.
+func resolveShortLink(rawURL string) (*VideoURL, error) {
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid url: %s", rawURL)
+	}
+	for i := 0; i < maxShortLinkRedirects; i++ {
+		rsp, err := client.Get(u.String())
+		if err != nil {
+			return nil, errors.Wrapf(err, "resolve short link: %s", rawURL)
+		}
+		_ = rsp.Body.Close()
+
+		if rsp.StatusCode < 300 || rsp.StatusCode >= 400 {
+			return parseVideoURL(u)
+		}
+		u, err = rsp.Location()
+		if err != nil {
+			return nil, errors.Wrapf(err, "resolve short link: %s", rawURL)
+		}
+	}
+	return nil, errors.Newf("too many redirects: %s", rawURL)
+}
+
+// parseVideoURL parses URLs like https://www.bilibili.com/video/BVxxxx?p=2,
+// av numbers in the path are converted to bvid.
+func parseVideoURL(u *url.URL) (*VideoURL, error) {
+	id, ok := strings.CutPrefix(u.Path, "/video/")
+	if !ok {
+		return nil, errors.Newf("not a video url: %s", u)
+	}
+	id = strings.Trim(id, "/")
+
+	v := &VideoURL{}
+	if m := aidPattern.FindStringSubmatch(id); m != nil {
+		aid, err := strconv.Atoi(m[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid aid: %s", id)
+		}
+		v.Bvid = convertAidToBvid(aid)
+	} else if bvidPattern.MatchString(id) {
+		v.Bvid = id
+	} else {
+		return nil, errors.Newf("invalid bvid: %s", id)
+	}
+
+	if p := u.Query().Get("p"); p != "" {
+		page, err := strconv.Atoi(p)
+		if err != nil || page < 1 {
+			return nil, errors.Newf("invalid page: %s", p)
+		}
+		v.Page = page
+	}
+	return v, nil
+}
@@ -0,0 +1,106 @@
+package bilibili
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestParseVideoURL(t *testing.T) {
+	tests := []struct {
+		input string
+		want  VideoURL
+	}{
+		{"https://www.bilibili.com/video/BV1xx411c7mD/?spm_id_from=333.1007", VideoURL{Bvid: "BV1xx411c7mD"}},
+		{"https://www.bilibili.com/video/BV1xx411c7mD?p=2", VideoURL{Bvid: "BV1xx411c7mD", Page: 2}},
+		{"https://m.bilibili.com/video/av170001", VideoURL{Bvid: convertAidToBvid(170001)}},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.input)
+		got, err := parseVideoURL(u)
+		if err != nil {
+			t.Errorf("parseVideoURL(%q): %v", tt.input, err)
+			continue
+		}
+		if *got != tt.want {
+			t.Errorf("parseVideoURL(%q) = %+v, want %+v", tt.input, *got, tt.want)
+		}
+	}
+
+	for _, input := range []string{
+		"https://space.bilibili.com/2",
+		"https://www.bilibili.com/video/BV1xx411/",
+		"https://www.bilibili.com/video/BV1xx411c7mD?p=0",
+	} {
+		u, _ := url.Parse(input)
+		_, err := parseVideoURL(u)
+		if err == nil {
+			t.Errorf("parseVideoURL(%q): expected error", input)
+		}
+	}
+}
+
+func TestResolveShortLink(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/abc", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/hop", http.StatusFound)
+	})
+	mux.HandleFunc("/hop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/video/BV1xx411c7mD?p=2&share_source=copy_web", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/video/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	v, err := resolveShortLink(server.URL + "/abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Bvid != "BV1xx411c7mD" || v.Page != 2 {
+		t.Errorf("resolveShortLink = %+v", *v)
+	}
+}
+
+func TestResolveVideoID(t *testing.T) {
+	for _, test := range []struct {
+		id   string
+		want VideoURL
+	}{
+		{id: "BV1y7411Q7Eq", want: VideoURL{Bvid: "BV1y7411Q7Eq"}},
+		{id: "av170001", want: VideoURL{Bvid: convertAidToBvid(170001)}},
+		{id: "https://www.bilibili.com/video/BV1y7411Q7Eq?p=2", want: VideoURL{Bvid: "BV1y7411Q7Eq", Page: 2}},
+	} {
+		got, err := resolveVideoID(test.id)
+		if err != nil {
+			t.Errorf("resolveVideoID(%q): %v", test.id, err)
+			continue
+		}
+		if *got != test.want {
+			t.Errorf("resolveVideoID(%q) = %+v, want %+v", test.id, *got, test.want)
+		}
+	}
+}
+
+func TestIsShortLink(t *testing.T) {
+	for _, test := range []struct {
+		url  string
+		want bool
+	}{
+		{url: "https://b23.tv/abc", want: true},
+		{url: "https://www.b23.tv/abc", want: true},
+		{url: "https://bili2233.cn/abc", want: true},
+		{url: "https://B23.TV:443/abc", want: true},
+		{url: "https://www.bilibili.com/video/BV1xx411c7mD", want: false},
+	} {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := isShortLink(u); got != test.want {
+			t.Errorf("isShortLink(%s) = %v, want %v", test.url, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package bilibili
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/urfave/cli/v3"
+	"go.uber.org/zap"
+)
+
+// WatchlistEntry is a creator whose new uploads `download watchlist`
+// downloads, the zero values of the filters fall back to the flags.
+type WatchlistEntry struct {
+	Mid         int           `yaml:"mid"`
+	Name        string        `yaml:"name,omitempty"`
+	MaxItems    int           `yaml:"max_items,omitempty"`
+	Since       time.Duration `yaml:"since,omitempty"`
+	MinDuration time.Duration `yaml:"min_duration,omitempty"`
+	MaxDuration time.Duration `yaml:"max_duration,omitempty"`
+}
+
+func (e *WatchlistEntry) String() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return strconv.Itoa(e.Mid)
+}
+
+// keep filters videos by the duration limits of the entry.
+func (e *WatchlistEntry) keep(v *SpaceVideo) bool {
+	if e.MinDuration == 0 && e.MaxDuration == 0 {
+		return true
+	}
+	duration, err := parseDuration(v.Length)
+	if err != nil {
+		return true
+	}
+	if duration < e.MinDuration || (e.MaxDuration > 0 && duration > e.MaxDuration) {
+		zap.L().Info("Skip video", zap.String("reason", "duration"), zap.String("bvid", v.Bvid),
+			zap.String("title", v.Title), zap.Duration("duration", duration))
+		return false
+	}
+	return true
+}
+
+type watchlistResult struct {
+	entry   *WatchlistEntry
+	summary *BatchSummary
+	err     error
+}
+
+func printWatchlistResults(results []watchlistResult) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "Creator\tMid\tNew\tDownloaded\tFailed\tError")
+	for _, r := range results {
+		var newVideos, downloaded, failed int
+		if r.summary != nil {
+			newVideos = r.summary.Total
+			downloaded = r.summary.Downloaded
+			failed = len(r.summary.Failed)
+		}
+		errText := ""
+		if r.err != nil {
+			errText = r.err.Error()
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%s\n", r.entry, r.entry.Mid, newVideos, downloaded, failed, errText)
+	}
+	return tw.Flush()
+}
+
+var downloadWatchlistCmd = &cli.Command{
+	Name:  "watchlist",
+	Usage: "Download new uploads of the creators in the watchlist of the config",
+	Flags: slices.Concat(
+		[]cli.Flag{
+			&cli.IntFlag{
+				Name:    "max-items",
+				Aliases: []string{"max", "m"},
+				Usage:   "Maximum videos of a creator, unless the watchlist entry sets max_items",
+				Value:   200,
+			},
+			&cli.IntFlag{
+				Name:  "concurrency",
+				Usage: "Number of videos to download in parallel",
+				Value: 1,
+			},
+			&cli.DurationFlag{
+				Name:  "since",
+				Usage: "Only download videos published within this duration, unless the watchlist entry sets since",
+			},
+		},
+		DownloadFlags(),
+		batchFlags(),
+		notifyFlags(),
+	),
+	Action: func(ctx context.Context, command *cli.Command) error {
+		d, err := downloaderFromCliCommand(command)
+		if err != nil {
+			return err
+		}
+		watchlist := d.config.Watchlist
+		if len(watchlist) == 0 {
+			return errors.New("watchlist is empty, add creators to the watchlist section of the config")
+		}
+
+		order := spaceVideoOrders["newest"]
+		total := &BatchSummary{}
+		results := make([]watchlistResult, 0, len(watchlist))
+		for i := range watchlist {
+			if ctx.Err() != nil {
+				break
+			}
+			entry := &watchlist[i]
+			maxItems := command.Int("max-items")
+			if entry.MaxItems > 0 {
+				maxItems = entry.MaxItems
+			}
+			since := sinceCutoff(command)
+			if entry.Since > 0 {
+				since = time.Now().Add(-entry.Since)
+			}
+
+			videos, err := d.collectSpaceVideos(ctx, entry.Mid, order, maxItems, since, entry.keep)
+			if err != nil {
+				// a creator failing doesn't stop the others
+				zap.L().Error("Get space videos failed", zap.Stringer("creator", entry), zap.Error(err))
+				results = append(results, watchlistResult{entry: entry, err: err})
+				// reported as a failure of the batch, so that scheduled runs
+				// notice the creator wasn't checked
+				total.Failed = append(total.Failed, BatchFailure{Title: "creator " + entry.String(), Err: err})
+				continue
+			}
+			options := spaceVideoOptions(videos)
+			if command.Bool("dry-run") {
+				fmt.Printf("%s (%d)\n", entry, entry.Mid)
+				err = printCandidates(options)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			summary := d.DownloadBatch(ctx, options, command.Int("concurrency"))
+			results = append(results, watchlistResult{entry: entry, summary: summary})
+			total.Total += summary.Total
+			total.Succeeded += summary.Succeeded
+			total.Failed = append(total.Failed, summary.Failed...)
+			total.Restricted = append(total.Restricted, summary.Restricted...)
+			total.NotStarted += summary.NotStarted
+			total.Downloaded += summary.Downloaded
+			total.TotalBytes += summary.TotalBytes
+		}
+		if command.Bool("dry-run") {
+			return nil
+		}
+
+		err = printWatchlistResults(results)
+		if err != nil {
+			return err
+		}
+		// videos skipped as already downloaded are counted by the downloader
+		// across the batches
+		total.AlreadyDownloaded = int(d.alreadyDownloaded.Load())
+		total.Candidates = total.Total + total.AlreadyDownloaded
+		return d.finishBatch(command, total)
+	},
+}
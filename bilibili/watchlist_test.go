@@ -0,0 +1,56 @@
+package bilibili
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadWatchlist(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yml")
+	err := os.WriteFile(configPath, []byte(`watchlist:
+  - mid: 1
+    name: a
+    since: 168h
+    min_duration: 1m
+  - mid: 2
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Watchlist) != 2 {
+		t.Fatalf("got %d watchlist entries, want 2", len(config.Watchlist))
+	}
+	entry := config.Watchlist[0]
+	if entry.Mid != 1 || entry.String() != "a" || entry.Since != 168*time.Hour || entry.MinDuration != time.Minute {
+		t.Errorf("first entry = %+v", entry)
+	}
+	if s := config.Watchlist[1].String(); s != "2" {
+		t.Errorf("String() without name = %q, want the mid", s)
+	}
+}
+
+func TestWatchlistEntryKeep(t *testing.T) {
+	entry := WatchlistEntry{MinDuration: time.Minute, MaxDuration: 10 * time.Minute}
+	for _, test := range []struct {
+		length string
+		keep   bool
+	}{
+		{length: "00:30", keep: false},
+		{length: "05:00", keep: true},
+		{length: "1:00:00", keep: false},
+	} {
+		if got := entry.keep(&SpaceVideo{Length: test.length}); got != test.keep {
+			t.Errorf("keep(%s) = %v, want %v", test.length, got, test.keep)
+		}
+	}
+	if !(&WatchlistEntry{}).keep(&SpaceVideo{Length: "00:01"}) {
+		t.Error("entry without filters should keep all videos")
+	}
+}
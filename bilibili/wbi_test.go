@@ -0,0 +1,26 @@
+package bilibili
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSignWbi(t *testing.T) {
+	mixinKey := getMixinKey("7cd084941338484aae1ad9425b84077c", "4932caff0ff746eab6f01bf08b70ac45")
+	if mixinKey != "ea1db124af3c7062474693fa704f4ff8" {
+		t.Fatalf("mixinKey = %s", mixinKey)
+	}
+
+	params := url.Values{}
+	params.Set("foo", "114")
+	params.Set("bar", "514")
+	params.Set("zab", "1919810")
+	signWbi(params, mixinKey, time.Unix(1702204169, 0))
+	if params.Get("wts") != "1702204169" {
+		t.Errorf("wts = %s", params.Get("wts"))
+	}
+	if params.Get("w_rid") != "8f6f2b5b3d485fe1886cec6a0be8c5d4" {
+		t.Errorf("w_rid = %s", params.Get("w_rid"))
+	}
+}
@@ -0,0 +1,264 @@
+// Package downloader downloads files over HTTP with read and overall
+// timeouts, retries, fallback URLs and a size limit.
+package downloader
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/go-resty/resty/v2"
+	"go.uber.org/zap"
+)
+
+var ErrFileTooLarge = errors.New("file too large")
+
+var ErrIncompleteDownload = errors.New("incomplete download")
+
+// ErrForbidden is returned when the server refuses the request, usually
+// because of missing headers or an expired URL.
+var ErrForbidden = errors.New("download forbidden")
+
+// PartFileSuffix marks files still being downloaded, they are renamed to the
+// final name only after the download completes.
+const PartFileSuffix = ".part"
+
+const (
+	DefaultAttempts      = 5
+	DefaultRetryInterval = time.Second
+)
+
+const maxBackoff = time.Minute
+
+// ProgressBar renders the progress of a download, bytes are written to it as
+// they are downloaded.
+type ProgressBar interface {
+	io.Writer
+	Finish() error
+	Exit() error
+}
+
+type Options struct {
+	// Client sends the requests, a default client is used if nil
+	Client *resty.Client
+	Header map[string]string
+
+	// ReadTimeout limits a single read of the response body, DownloadTimeout
+	// a whole attempt, 0 means no timeout
+	ReadTimeout     time.Duration
+	DownloadTimeout time.Duration
+
+	// MaxFileSize rejects files of this size or larger, 0 means no limit
+	MaxFileSize int64
+
+	// Attempts limits the attempts of a file with a single URL, retried with
+	// exponential backoff from RetryInterval. Files with several URLs try
+	// each of them once instead.
+	Attempts      int
+	RetryInterval time.Duration
+
+	// Progress is called when a response starts and returns the bar to
+	// render its progress, nil means no progress bar
+	Progress func(fileName string, contentLength int64) ProgressBar
+}
+
+// Download downloads the first of urls that works to filePath.
+func Download(ctx context.Context, filePath string, urls []string, opts Options) error {
+	if len(urls) == 0 {
+		return errors.New("urls is empty")
+	}
+	if opts.Client == nil {
+		opts.Client = resty.New()
+	}
+	if opts.Attempts <= 0 {
+		opts.Attempts = DefaultAttempts
+	}
+
+	// remove the leftover of an interrupted run
+	_ = os.Remove(filePath + PartFileSuffix)
+
+	var lastErr error
+	if len(urls) > 1 {
+		for _, url := range urls {
+			err := downloadSingleFile(ctx, filePath, url, &opts)
+			if err != nil {
+				lastErr = err
+				if errors.Is(err, ErrFileTooLarge) || ctx.Err() != nil {
+					return err
+				}
+				zap.L().Error("Download file failed, try next URL",
+					zap.String("file", filepath.Base(filePath)), zap.Error(err))
+				continue
+			}
+			return nil
+		}
+	}
+
+	if len(urls) == 1 {
+		for attempt := 0; attempt < opts.Attempts; attempt++ {
+			err := downloadSingleFile(ctx, filePath, urls[0], &opts)
+			if err != nil {
+				lastErr = err
+				if errors.Is(err, ErrFileTooLarge) || ctx.Err() != nil {
+					return err
+				}
+				if attempt+1 >= opts.Attempts {
+					break
+				}
+				backoff := retryBackoff(opts.RetryInterval, attempt)
+				zap.L().Error("Download file failed, try again later",
+					zap.String("file", filepath.Base(filePath)), zap.Duration("backoff", backoff), zap.Error(err))
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(backoff):
+				}
+			} else {
+				return nil
+			}
+		}
+	}
+
+	fileName := filepath.Base(filePath)
+	if lastErr == nil {
+		return errors.Newf("download %s failed", fileName)
+	}
+	return errors.Wrapf(lastErr, "download %s failed", fileName)
+}
+
+// retryBackoff doubles interval every attempt up to maxBackoff, with up to
+// half of it randomized so that retries don't arrive together.
+func retryBackoff(interval time.Duration, attempt int) time.Duration {
+	backoff := min(interval<<attempt, maxBackoff)
+	if backoff <= 0 {
+		return 0
+	}
+	jitter := backoff / 2
+	return backoff - jitter + rand.N(jitter+1)
+}
+
+func getContentLength(header http.Header) int64 {
+	s := header.Get("Content-Length")
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return v
+}
+
+func downloadSingleFile(ctx context.Context, filePath string, url string, opts *Options) (err error) {
+	fileName := filepath.Base(filePath)
+	partPath := filePath + PartFileSuffix
+	f, err := os.OpenFile(partPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+		if err != nil {
+			_ = os.Remove(partPath)
+		}
+	}()
+
+	// the client is shared with other downloads, so the timeout goes to the
+	// context of the request instead
+	if opts.DownloadTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.DownloadTimeout)
+		defer cancel()
+	}
+
+	rsp, err := opts.Client.R().SetContext(ctx).SetDoNotParseResponse(true).
+		SetHeaders(opts.Header).
+		Get(url)
+	if err != nil {
+		return err
+	}
+	body := rsp.RawBody()
+	defer func() { _ = body.Close() }()
+	if rsp.StatusCode() == http.StatusForbidden {
+		return errors.Wrapf(ErrForbidden, "file: %s, url: %s", fileName, url)
+	}
+	if rsp.IsError() {
+		return errors.Newf("download %s failed, status: %s", fileName, rsp.Status())
+	}
+
+	contentLength := getContentLength(rsp.Header())
+	if opts.MaxFileSize > 0 && contentLength >= opts.MaxFileSize {
+		return errors.Wrapf(ErrFileTooLarge, "file: %s", fileName)
+	}
+
+	writer := io.Writer(f)
+	if opts.Progress != nil {
+		if bar := opts.Progress(fileName, contentLength); bar != nil {
+			defer func() {
+				if err != nil {
+					_ = bar.Exit()
+				} else {
+					_ = bar.Finish()
+				}
+			}()
+			writer = io.MultiWriter(f, bar)
+		}
+	}
+
+	buf := make([]byte, 1*1024*1024)
+	written := int64(0)
+
+	for {
+		n, readErr := readChunk(ctx, body, buf, opts.ReadTimeout)
+		if n > 0 {
+			_, err = writer.Write(buf[:n])
+			if err != nil {
+				return err
+			}
+			written += int64(n)
+		}
+		if readErr != nil {
+			if errors.Is(readErr, io.EOF) {
+				break
+			}
+			return readErr
+		}
+	}
+
+	if contentLength >= 0 && written != contentLength {
+		return errors.Wrapf(ErrIncompleteDownload, "file: %s, expected %d bytes, got %d", fileName, contentLength, written)
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(partPath, filePath)
+}
+
+func readChunk(ctx context.Context, r io.Reader, buf []byte, timeout time.Duration) (int, error) {
+	if timeout <= 0 {
+		return readWithContext(ctx, r, buf)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return readWithContext(ctx, r, buf)
+}
+
+func readWithContext(ctx context.Context, r io.Reader, buf []byte) (n int, err error) {
+	done := make(chan struct{})
+	go func() {
+		n, err = r.Read(buf)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return n, err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
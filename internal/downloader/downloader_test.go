@@ -0,0 +1,182 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/go-resty/resty/v2"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		for range 10 {
+			got := retryBackoff(time.Second, attempt)
+			if got < want/2 || got > want {
+				t.Errorf("retryBackoff(1s, %d) = %v, want in [%v, %v]", attempt, got, want/2, want)
+			}
+		}
+	}
+	got := retryBackoff(time.Second, 20)
+	if got < maxBackoff/2 || got > maxBackoff {
+		t.Errorf("retryBackoff(1s, 20) = %v, want capped at %v", got, maxBackoff)
+	}
+}
+
+func TestDownloadRetry(t *testing.T) {
+	const failures = 2
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("content"))
+	}))
+	defer server.Close()
+
+	filePath := filepath.Join(t.TempDir(), "file")
+	err := Download(context.Background(), filePath, []string{server.URL}, Options{
+		Attempts:      failures + 1,
+		RetryInterval: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "content" {
+		t.Errorf("content = %q, want %q", buf, "content")
+	}
+	if n := requests.Load(); n != failures+1 {
+		t.Errorf("requests = %d, want %d", n, failures+1)
+	}
+}
+
+func TestDownloadHeader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Referer") != "https://example.com" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte("content"))
+	}))
+	defer server.Close()
+
+	filePath := filepath.Join(t.TempDir(), "file")
+	err := Download(context.Background(), filePath, []string{server.URL}, Options{Attempts: 1})
+	if !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+
+	err = Download(context.Background(), filePath, []string{server.URL}, Options{
+		Attempts: 1,
+		Header:   map[string]string{"Referer": "https://example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			_, _ = w.Write([]byte("content"))
+		case "/truncated":
+			w.Header().Set("Content-Length", "100")
+			_, _ = w.Write([]byte("content"))
+		case "/stuck":
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte("content"))
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	for _, test := range []struct {
+		name string
+		urls []string
+		opts Options
+		err  error
+	}{
+		{name: "ok", urls: []string{"/ok"}},
+		{name: "fallback", urls: []string{"/missing", "/ok"}},
+		{name: "truncated", urls: []string{"/truncated"}, err: io.ErrUnexpectedEOF},
+		{name: "too large", urls: []string{"/ok"}, opts: Options{MaxFileSize: 5}, err: ErrFileTooLarge},
+		{name: "read timeout", urls: []string{"/stuck"}, opts: Options{ReadTimeout: 50 * time.Millisecond},
+			err: context.DeadlineExceeded},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			urls := make([]string, 0, len(test.urls))
+			for _, u := range test.urls {
+				urls = append(urls, server.URL+u)
+			}
+			test.opts.Attempts = 1
+			filePath := filepath.Join(t.TempDir(), "file")
+
+			err := Download(context.Background(), filePath, urls, test.opts)
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("expected %v, got %v", test.err, err)
+				}
+				if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+					t.Errorf("file should not exist, stat: %v", err)
+				}
+				if _, err := os.Stat(filePath + PartFileSuffix); !os.IsNotExist(err) {
+					t.Errorf("part file should be removed, stat: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf, err := os.ReadFile(filePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(buf) != "content" {
+				t.Errorf("content = %q, want %q", buf, "content")
+			}
+		})
+	}
+}
+
+func TestDownloadTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	client := resty.New()
+	filePath := filepath.Join(t.TempDir(), "file")
+	err := Download(context.Background(), filePath, []string{server.URL}, Options{
+		Client:          client,
+		Attempts:        1,
+		DownloadTimeout: 50 * time.Millisecond,
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Download() of a stuck server = %v, want context.DeadlineExceeded", err)
+	}
+	if timeout := client.GetClient().Timeout; timeout != 0 {
+		t.Errorf("timeout of the shared client changed to %v", timeout)
+	}
+}
@@ -2,34 +2,181 @@ package main
 
 import (
 	"context"
+	"net/url"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
+	"github.com/cockroachdb/errors"
 	"github.com/urfave/cli/v3"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 
 	"github.com/fanyang89/media-collector/bilibili"
+	"github.com/fanyang89/media-collector/source"
 )
 
 var cmd = &cli.Command{
 	Name:  "media-collector",
 	Usage: "Media collector",
+	// `media-collector completion bash|zsh|fish|pwsh` prints the script
+	EnableShellCompletion: true,
+	ConfigureShellCompletionCommand: func(command *cli.Command) {
+		command.Hidden = false
+	},
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "log-format",
+			Usage:   "Log format, console or json",
+			Value:   "console",
+			Sources: cli.EnvVars("LOG_FORMAT"),
+		},
+		&cli.StringFlag{
+			Name:    "log-level",
+			Usage:   "Log level, debug, info, warn or error",
+			Value:   "info",
+			Sources: cli.EnvVars("LOG_LEVEL"),
+		},
+		&cli.BoolFlag{
+			Name:    "verbose",
+			Aliases: []string{"v"},
+			Usage:   "Print debug logs, same as --log-level debug",
+		},
+		&cli.BoolFlag{
+			Name:    "quiet",
+			Aliases: []string{"q"},
+			Usage:   "Print error logs only, same as --log-level error",
+		},
+		&cli.BoolFlag{
+			Name:  "no-progress",
+			Usage: "Don't render progress bars, they are disabled when stdout is not a terminal",
+		},
+	},
+	Before: func(ctx context.Context, command *cli.Command) (context.Context, error) {
+		level := command.String("log-level")
+		if command.Bool("verbose") && command.Bool("quiet") {
+			return ctx, errors.New("--verbose and --quiet can't be used together")
+		}
+		if command.Bool("verbose") {
+			level = "debug"
+		} else if command.Bool("quiet") {
+			level = "error"
+		}
+
+		logger, err := newLogger(command.String("log-format"), level)
+		if err != nil {
+			return ctx, err
+		}
+		_ = zap.L().Sync()
+		zap.ReplaceGlobals(logger)
+
+		if command.Bool("no-progress") {
+			bilibili.SetProgressEnabled(false)
+		}
+		return ctx, nil
+	},
 	Commands: []*cli.Command{
+		getCmd,
+		versionCmd,
+		bilibili.ConfigCmd,
+		bilibili.InstallFFmpegCmd,
 		bilibili.RootCmd,
 	},
 }
 
+var getCmd = &cli.Command{
+	Name:  "get",
+	Usage: "Download media of a URL with the matching platform",
+	Arguments: []cli.Argument{
+		&cli.StringArg{Name: "url", Config: cli.StringConfig{TrimSpace: true}},
+	},
+	Flags: bilibili.DownloadFlags(),
+	Action: func(ctx context.Context, command *cli.Command) error {
+		rawURL := command.StringArg("url")
+		if rawURL == "" {
+			return errors.New("url is required")
+		}
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return errors.Wrapf(err, "invalid url: %s", rawURL)
+		}
+
+		platform := platformOf(u.Hostname())
+		if platform == "" {
+			return errors.Newf("unsupported url: %s", rawURL)
+		}
+		newSource, ok := sources[platform]
+		if !ok {
+			return errors.Newf("%s is not supported yet: %s", platform, rawURL)
+		}
+		src, err := newSource(command)
+		if err != nil {
+			return err
+		}
+		return src.Download(ctx, rawURL)
+	},
+}
+
+// sources are the platforms media can be downloaded from by URL.
+var sources = map[string]source.Factory{
+	"bilibili": bilibili.NewSourceFromCliCommand,
+}
+
+func platformOf(host string) string {
+	host = strings.ToLower(host)
+	matches := func(domains ...string) bool {
+		for _, domain := range domains {
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case matches("bilibili.com", "b23.tv", "bili2233.cn"):
+		return "bilibili"
+	case matches("xiaohongshu.com", "xhslink.com"):
+		return "xiaohongshu"
+	}
+	return ""
+}
+
+func newLogger(format string, level string) (*zap.Logger, error) {
+	var config zap.Config
+	switch format {
+	case "console":
+		config = zap.NewDevelopmentConfig()
+		config.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+	case "json":
+		config = zap.NewProductionConfig()
+	default:
+		return nil, errors.Newf("invalid log format: %s", format)
+	}
+
+	l, err := zap.ParseAtomicLevel(level)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid log level: %s", level)
+	}
+	config.Level = l
+
+	return config.Build(zap.AddCaller(), zap.AddCallerSkip(1))
+}
+
 func main() {
-	config := zap.NewDevelopmentConfig()
-	config.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
-	logger, err := config.Build(zap.AddCaller(), zap.AddCallerSkip(1))
+	logger, err := newLogger("console", "info")
 	if err != nil {
 		panic(err)
 	}
 	zap.ReplaceGlobals(logger)
-	defer func() { _ = logger.Sync() }()
+	defer func() { _ = zap.L().Sync() }()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	err = cmd.Run(context.Background(), os.Args)
+	cmd.Version = readBuildInfo().String()
+	err = cmd.Run(ctx, os.Args)
 	if err != nil {
 		zap.L().Error("Unexpected error", zap.Error(err))
 	}
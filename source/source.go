@@ -0,0 +1,53 @@
+// Package source defines what commands need from a site media is collected
+// from, so that they work with any site without depending on its package.
+package source
+
+import (
+	"context"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+// Item is a piece of media found on a source.
+type Item struct {
+	ID       string
+	Title    string
+	Author   string
+	Duration time.Duration
+}
+
+const (
+	StreamVideo = "video"
+	StreamAudio = "audio"
+	// StreamMuxed is a stream with both video and audio
+	StreamMuxed = "muxed"
+)
+
+// Stream is a downloadable stream of an item, URLs are tried in order.
+type Stream struct {
+	Kind   string
+	Format string
+	URLs   []string
+}
+
+// StreamInfo is an item with the streams to download it, which are the best
+// ones the source offers.
+type StreamInfo struct {
+	Item
+	Streams []Stream
+}
+
+// Source is a site media is collected from. IDs are whatever the site uses
+// to identify an item, URLs of items are accepted as well.
+type Source interface {
+	Name() string
+	Resolve(ctx context.Context, id string) (*StreamInfo, error)
+	Search(ctx context.Context, query string) ([]Item, error)
+	// Download downloads the item to the output directory and records it in
+	// history.
+	Download(ctx context.Context, id string) error
+}
+
+// Factory creates a source configured by the flags of command.
+type Factory func(command *cli.Command) (Source, error)
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/urfave/cli/v3"
+)
+
+// Set with -ldflags "-X main.version=... -X main.commit=... -X main.date=...",
+// build info recorded by the go command is used for the ones not set.
+var (
+	version = ""
+	commit  = ""
+	date    = ""
+)
+
+type buildInfo struct {
+	Version string
+	Commit  string
+	Date    string
+}
+
+func readBuildInfo() buildInfo {
+	info := buildInfo{Version: version, Commit: commit, Date: date}
+	bi, ok := debug.ReadBuildInfo()
+	if ok {
+		if info.Version == "" && bi.Main.Version != "" {
+			info.Version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				if info.Date == "" {
+					info.Date = s.Value
+				}
+			}
+		}
+	}
+	if info.Version == "" {
+		info.Version = "(devel)"
+	}
+	return info
+}
+
+func (i buildInfo) String() string {
+	s := i.Version
+	if i.Commit != "" {
+		s += ", commit " + i.Commit
+	}
+	if i.Date != "" {
+		s += ", built at " + i.Date
+	}
+	return s
+}
+
+var versionCmd = &cli.Command{
+	Name:  "version",
+	Usage: "Print the version",
+	Action: func(ctx context.Context, command *cli.Command) error {
+		fmt.Printf("media-collector %s\n", readBuildInfo())
+		return nil
+	},
+}
+
+func init() {
+	// -v is --verbose
+	cli.VersionFlag = &cli.BoolFlag{
+		Name:        "version",
+		Usage:       "Print the version",
+		HideDefault: true,
+		Local:       true,
+	}
+}